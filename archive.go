@@ -0,0 +1,467 @@
+package chirpstore
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"sync"
+
+	"github.com/creachadair/ffs/blob"
+	"github.com/creachadair/taskgroup"
+)
+
+// Archive format
+//
+// An archive is a portable snapshot of the contents of a keyspace. It consists
+// of a header, followed by zero or more records, followed by a trailer:
+//
+//	archive = header record* trailer
+//	header  = "CSAR" [1] version
+//	record  = "R" [U] klen [k] key [U] dlen [d] data [4] crc
+//	trailer = "E" [U] count [32] digest
+//
+// Here [U] denotes an unsigned varint as defined by encoding/binary, and [n]
+// denotes n bytes. The version is currently 1.
+//
+// The crc of a record is the CRC-32 (Castagnoli) checksum of the bytes of the
+// record preceding it, in big-endian order. Records are stored in strictly
+// increasing order by key. The count of the trailer is the number of records,
+// and its digest is the SHA-256 of the concatenated bytes of all the records
+// in the archive, in order.
+
+const (
+	archiveMagic   = "CSAR"
+	archiveVersion = 1
+
+	archiveRecord  = 'R'
+	archiveTrailer = 'E'
+
+	// The maximum length of a key or value accepted from an archive.
+	maxArchiveLen = 1 << 30
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// An ArchiveWriter writes records to an archive.
+type ArchiveWriter struct {
+	w       io.Writer
+	sum     hash.Hash // digest of records written so far
+	n       int64     // number of records written
+	last    string    // the last key written
+	buf     []byte
+	hasLast bool
+	resume  func() error // if non-nil, call before the first write
+}
+
+// NewArchiveWriter constructs an ArchiveWriter that writes a new archive to w.
+// It reports an error if the archive header cannot be written.
+func NewArchiveWriter(w io.Writer) (*ArchiveWriter, error) {
+	if _, err := w.Write(append([]byte(archiveMagic), archiveVersion)); err != nil {
+		return nil, fmt.Errorf("write archive header: %w", err)
+	}
+	return &ArchiveWriter{w: w, sum: sha256.New()}, nil
+}
+
+// An ArchiveFile is a file to which an archive is written. An *os.File
+// satisfies this interface.
+type ArchiveFile interface {
+	io.ReadWriteSeeker
+	Truncate(size int64) error
+}
+
+// ResumeArchive constructs an ArchiveWriter that appends to the archive
+// already stored in f. If f is empty, a new archive is started. Otherwise f
+// must begin with a valid archive header, or ResumeArchive reports an error
+// without modifying f.
+//
+// Any incomplete or corrupted data following the last valid record of the
+// archive is discarded, along with the trailer if the archive was complete.
+// This happens only when the first record is added or the writer is closed,
+// so f is not modified if the caller abandons the writer before then.
+//
+// Use [ArchiveWriter.LastKey] to recover the last key written.
+func ResumeArchive(f ArchiveFile) (*ArchiveWriter, error) {
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	} else if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if size == 0 {
+		return NewArchiveWriter(f)
+	}
+	er := &errReader{r: f}
+	ar, err := NewArchiveReader(er)
+	if err != nil {
+		return nil, fmt.Errorf("resume archive: %w", err)
+	}
+
+	// Scan forward to the end of the last valid record. An error here means
+	// we have found the end of the usable data, unless it was an error
+	// reading f itself.
+	end := ar.r.pos
+	for {
+		if _, _, err := ar.Next(); err != nil {
+			break
+		}
+		end = ar.r.pos
+	}
+	if er.err != nil {
+		return nil, fmt.Errorf("resume archive: %w", er.err)
+	}
+	return &ArchiveWriter{
+		w:       f,
+		sum:     ar.sum,
+		n:       ar.n,
+		last:    ar.last,
+		hasLast: ar.n != 0,
+		resume: func() error {
+			if err := f.Truncate(end); err != nil {
+				return err
+			}
+			_, err := f.Seek(end, io.SeekStart)
+			return err
+		},
+	}, nil
+}
+
+// errReader wraps an io.Reader to record the first error other than io.EOF
+// reported by its input.
+type errReader struct {
+	r   io.Reader
+	err error
+}
+
+func (e *errReader) Read(data []byte) (int, error) {
+	nr, err := e.r.Read(data)
+	if err != nil && err != io.EOF && e.err == nil {
+		e.err = err
+	}
+	return nr, err
+}
+
+// start prepares the output of w for writing, if it has not already done so.
+func (w *ArchiveWriter) start() error {
+	if w.resume != nil {
+		if err := w.resume(); err != nil {
+			return fmt.Errorf("resume archive: %w", err)
+		}
+		w.resume = nil
+	}
+	return nil
+}
+
+// Add adds a record for the given key and value to the archive. Keys must be
+// added in strictly increasing order.
+func (w *ArchiveWriter) Add(key string, data []byte) error {
+	if w.hasLast && key <= w.last {
+		return fmt.Errorf("archive key %q out of order", key)
+	} else if err := w.start(); err != nil {
+		return err
+	}
+	w.buf = appendRecord(w.buf[:0], key, data)
+	if _, err := w.w.Write(w.buf); err != nil {
+		return err
+	}
+	w.sum.Write(w.buf)
+	w.n++
+	w.last, w.hasLast = key, true
+	return nil
+}
+
+// LastKey reports the last key written to the archive, and whether any
+// records have been written.
+func (w *ArchiveWriter) LastKey() (string, bool) { return w.last, w.hasLast }
+
+// Len reports the number of records written to the archive.
+func (w *ArchiveWriter) Len() int64 { return w.n }
+
+// Close writes the trailer of the archive. It does not close the underlying
+// writer. No further records may be added after Close.
+func (w *ArchiveWriter) Close() error {
+	if err := w.start(); err != nil {
+		return err
+	}
+	buf := binary.AppendUvarint([]byte{archiveTrailer}, uint64(w.n))
+	_, err := w.w.Write(w.sum.Sum(buf))
+	return err
+}
+
+func appendRecord(buf []byte, key string, data []byte) []byte {
+	buf = append(buf, archiveRecord)
+	buf = binary.AppendUvarint(buf, uint64(len(key)))
+	buf = append(buf, key...)
+	buf = binary.AppendUvarint(buf, uint64(len(data)))
+	buf = append(buf, data...)
+	return binary.BigEndian.AppendUint32(buf, crc32.Checksum(buf, crcTable))
+}
+
+// An ArchiveReader reads records from an archive.
+type ArchiveReader struct {
+	r    *countReader
+	sum  hash.Hash // digest of records read so far
+	n    int64     // number of records read
+	last string    // the last key read
+	buf  []byte
+	done bool
+}
+
+// NewArchiveReader constructs an ArchiveReader that reads an archive from r.
+// It reports an error if r does not begin with a valid archive header.
+func NewArchiveReader(r io.Reader) (*ArchiveReader, error) {
+	cr := &countReader{br: bufio.NewReader(r)}
+	var hdr [len(archiveMagic) + 1]byte
+	if _, err := io.ReadFull(cr, hdr[:]); err != nil {
+		return nil, fmt.Errorf("read archive header: %w", err)
+	} else if string(hdr[:len(archiveMagic)]) != archiveMagic {
+		return nil, errors.New("invalid archive header")
+	} else if v := hdr[len(archiveMagic)]; v != archiveVersion {
+		return nil, fmt.Errorf("unsupported archive version %d", v)
+	}
+	return &ArchiveReader{r: cr, sum: sha256.New()}, nil
+}
+
+// Next reads the next record from the archive and returns its key and value.
+// At the end of the archive, Next verifies the trailer and reports [io.EOF]
+// if the archive is complete and intact; otherwise it reports an error
+// describing the problem.
+func (r *ArchiveReader) Next() (string, []byte, error) {
+	if r.done {
+		return "", nil, io.EOF
+	}
+	tag, err := r.r.ReadByte()
+	if err != nil {
+		return "", nil, fmt.Errorf("read archive: %w", noEOF(err))
+	}
+	switch tag {
+	case archiveRecord:
+		return r.readRecord()
+	case archiveTrailer:
+		return "", nil, r.readTrailer()
+	default:
+		return "", nil, fmt.Errorf("invalid archive tag %q", tag)
+	}
+}
+
+// Len reports the number of records read from the archive so far.
+func (r *ArchiveReader) Len() int64 { return r.n }
+
+func (r *ArchiveReader) readRecord() (string, []byte, error) {
+	key, err := r.readField()
+	if err != nil {
+		return "", nil, fmt.Errorf("read archive key: %w", err)
+	}
+	data, err := r.readField()
+	if err != nil {
+		return "", nil, fmt.Errorf("read archive value: %w", err)
+	}
+	var crc [4]byte
+	if _, err := io.ReadFull(r.r, crc[:]); err != nil {
+		return "", nil, fmt.Errorf("read archive checksum: %w", noEOF(err))
+	}
+
+	r.buf = appendRecord(r.buf[:0], string(key), data)
+	if !bytes.Equal(r.buf[len(r.buf)-4:], crc[:]) {
+		return "", nil, fmt.Errorf("archive record %d: checksum mismatch", r.n+1)
+	} else if r.n != 0 && string(key) <= r.last {
+		return "", nil, fmt.Errorf("archive key %q out of order", key)
+	}
+	r.sum.Write(r.buf)
+	r.n++
+	r.last = string(key)
+	return r.last, data, nil
+}
+
+func (r *ArchiveReader) readField() ([]byte, error) {
+	n, err := binary.ReadUvarint(r.r)
+	if err != nil {
+		return nil, noEOF(err)
+	} else if n > maxArchiveLen {
+		return nil, fmt.Errorf("length %d exceeds limit", n)
+	}
+	// Do not trust n for the size of the buffer, which grows only as data
+	// arrive, so that a damaged length cannot force a large allocation.
+	buf, err := io.ReadAll(io.LimitReader(r.r, int64(n)))
+	if err != nil {
+		return nil, err
+	} else if uint64(len(buf)) != n {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return buf, nil
+}
+
+func (r *ArchiveReader) readTrailer() error {
+	n, err := binary.ReadUvarint(r.r)
+	if err != nil {
+		return fmt.Errorf("read archive trailer: %w", noEOF(err))
+	}
+	var sum [sha256.Size]byte
+	if _, err := io.ReadFull(r.r, sum[:]); err != nil {
+		return fmt.Errorf("read archive trailer: %w", noEOF(err))
+	}
+	if int64(n) != r.n {
+		return fmt.Errorf("archive trailer: got %d records, want %d", r.n, n)
+	} else if !bytes.Equal(r.sum.Sum(nil), sum[:]) {
+		return errors.New("archive trailer: digest mismatch")
+	} else if _, err := r.r.ReadByte(); err != io.EOF {
+		return errors.New("extra data after archive trailer")
+	}
+	r.done = true
+	return io.EOF
+}
+
+// noEOF converts io.EOF into io.ErrUnexpectedEOF, for use when reading the
+// interior of an archive where the input must not end.
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// countReader wraps a bufio.Reader to track the offset of the next unread
+// byte of its input.
+type countReader struct {
+	br  *bufio.Reader
+	pos int64
+}
+
+func (c *countReader) Read(data []byte) (int, error) {
+	nr, err := c.br.Read(data)
+	c.pos += int64(nr)
+	return nr, err
+}
+
+func (c *countReader) ReadByte() (byte, error) {
+	b, err := c.br.ReadByte()
+	if err == nil {
+		c.pos++
+	}
+	return b, err
+}
+
+// Export writes the keys and values of kv to w in key order, and reports the
+// number of records written. If w already contains records, as when resuming
+// with [ResumeArchive], Export begins after the last key written to w.
+// Export does not close w; the caller must do so to complete the archive.
+func Export(ctx context.Context, kv blob.KVCore, w *ArchiveWriter) (int64, error) {
+	var start string
+	if last, ok := w.LastKey(); ok {
+		start = last + "\x00" // the next possible key
+	}
+	var nw int64
+	for key, err := range kv.List(ctx, start) {
+		if err != nil {
+			return nw, err
+		}
+		data, err := kv.Get(ctx, key)
+		if blob.IsKeyNotFound(err) {
+			continue // deleted since it was listed
+		} else if err != nil {
+			return nw, err
+		}
+		if err := w.Add(key, data); err != nil {
+			return nw, err
+		}
+		nw++
+	}
+	return nw, nil
+}
+
+// ImportOptions are optional settings for [Import].
+// A nil *ImportOptions is ready for use and provides default values.
+type ImportOptions struct {
+	// If true, replace the values of keys already present in the keyspace.
+	// Otherwise, records for existing keys are skipped.
+	Replace bool
+
+	// If true, verify that the key of each record is the content address of
+	// its value, as computed by [blob.CASFromKV]. Records that do not match
+	// are counted as failures and not written.
+	VerifyCAS bool
+
+	// The maximum number of concurrent writes to the keyspace.
+	// If zero, a default limit is used.
+	Concurrency int
+}
+
+func (o *ImportOptions) replace() bool   { return o != nil && o.Replace }
+func (o *ImportOptions) verifyCAS() bool { return o != nil && o.VerifyCAS }
+
+func (o *ImportOptions) concurrency() int {
+	if o == nil || o.Concurrency <= 0 {
+		return 16
+	}
+	return o.Concurrency
+}
+
+// ImportStats report the results of a call to [Import].
+type ImportStats struct {
+	Written int64 // records written to the keyspace
+	Skipped int64 // records skipped because their key already exists
+	Failed  int64 // records that could not be written
+}
+
+// Import writes the records of the archive read by r into kv, and reports the
+// number of records written, skipped, and failed.
+//
+// Failed records do not stop the import. If any records fail, Import reports
+// an error describing the first failure after all the other records have been
+// processed. An error reading the archive ends the import immediately.
+func Import(ctx context.Context, kv blob.KV, r *ArchiveReader, opts *ImportOptions) (ImportStats, error) {
+	var cas blob.CAS
+	if opts.verifyCAS() {
+		cas = blob.CASFromKV(kv)
+	}
+	replace := opts.replace()
+
+	var μ sync.Mutex
+	var stats ImportStats
+	var failure error
+	record := func(key string, err error) {
+		μ.Lock()
+		defer μ.Unlock()
+		switch {
+		case err == nil:
+			stats.Written++
+		case blob.IsKeyExists(err):
+			stats.Skipped++
+		default:
+			stats.Failed++
+			if failure == nil {
+				failure = fmt.Errorf("import key %q: %w", key, err)
+			}
+		}
+	}
+
+	g, start := taskgroup.New(nil).Limit(opts.concurrency())
+	for {
+		key, data, err := r.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			g.Wait()
+			return stats, err
+		}
+		start(func() error {
+			if cas != nil && cas.CASKey(ctx, data) != key {
+				record(key, errors.New("content address mismatch"))
+			} else {
+				record(key, kv.Put(ctx, blob.PutOptions{Key: key, Data: data, Replace: replace}))
+			}
+			return nil
+		})
+	}
+	g.Wait()
+	if failure != nil {
+		return stats, fmt.Errorf("import: %d records failed: %w", stats.Failed, failure)
+	}
+	return stats, nil
+}
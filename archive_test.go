@@ -0,0 +1,301 @@
+package chirpstore_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/creachadair/chirpstore"
+	"github.com/creachadair/ffs/blob"
+	"github.com/creachadair/ffs/blob/memstore"
+	"github.com/google/go-cmp/cmp"
+)
+
+func newTestKV(t *testing.T, n int) (chirpstore.KV, map[string]string) {
+	t.Helper()
+	rs := chirpstore.NewStore(newTestService(t, nil), nil)
+	kv := mustKV(t, rs, "test")
+	want := make(map[string]string)
+	for i := range n {
+		key, val := fmt.Sprintf("key-%04d", i), fmt.Sprintf("value %d", i)
+		if err := kv.Put(t.Context(), blob.PutOptions{Key: key, Data: []byte(val)}); err != nil {
+			t.Fatalf("Put %q: unexpected error: %v", key, err)
+		}
+		want[key] = val
+	}
+	return kv, want
+}
+
+func readArchive(t *testing.T, r io.Reader) map[string]string {
+	t.Helper()
+	ar, err := chirpstore.NewArchiveReader(r)
+	if err != nil {
+		t.Fatalf("NewArchiveReader: unexpected error: %v", err)
+	}
+	got := make(map[string]string)
+	for {
+		key, data, err := ar.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("Next: unexpected error: %v", err)
+		}
+		got[key] = string(data)
+	}
+	return got
+}
+
+func TestArchive(t *testing.T) {
+	kv, want := newTestKV(t, 50)
+
+	var buf bytes.Buffer
+	aw, err := chirpstore.NewArchiveWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewArchiveWriter: unexpected error: %v", err)
+	}
+	if n, err := chirpstore.Export(t.Context(), kv, aw); err != nil {
+		t.Fatalf("Export: unexpected error: %v", err)
+	} else if n != int64(len(want)) {
+		t.Errorf("Export: got %d records, want %d", n, len(want))
+	}
+	if err := aw.Close(); err != nil {
+		t.Fatalf("Close: unexpected error: %v", err)
+	}
+	archive := buf.Bytes()
+
+	t.Run("RoundTrip", func(t *testing.T) {
+		got := readArchive(t, bytes.NewReader(archive))
+		if diff := cmp.Diff(got, want); diff != "" {
+			t.Errorf("Archive contents (-got, +want):\n%s", diff)
+		}
+	})
+
+	t.Run("Corrupt", func(t *testing.T) {
+		bad := bytes.Clone(archive)
+		bad[len(bad)/2] ^= 0x20
+		ar, err := chirpstore.NewArchiveReader(bytes.NewReader(bad))
+		if err != nil {
+			t.Fatalf("NewArchiveReader: unexpected error: %v", err)
+		}
+		for {
+			_, _, err := ar.Next()
+			if err == io.EOF {
+				t.Fatal("Next: corrupt archive was accepted")
+			} else if err != nil {
+				t.Logf("Next: got expected error: %v", err)
+				break
+			}
+		}
+	})
+
+	t.Run("Truncated", func(t *testing.T) {
+		ar, err := chirpstore.NewArchiveReader(bytes.NewReader(archive[:len(archive)-10]))
+		if err != nil {
+			t.Fatalf("NewArchiveReader: unexpected error: %v", err)
+		}
+		for {
+			_, _, err := ar.Next()
+			if err == io.EOF {
+				t.Fatal("Next: truncated archive was accepted")
+			} else if errors.Is(err, io.ErrUnexpectedEOF) {
+				break
+			} else if err != nil {
+				t.Fatalf("Next: got %v, want %v", err, io.ErrUnexpectedEOF)
+			}
+		}
+	})
+
+	t.Run("HugeField", func(t *testing.T) {
+		// A record claiming a very long key, but ending early, is reported as
+		// truncated without allocating space for the claimed length.
+		i := bytes.Index(archive, []byte("\x08key-0000"))
+		if i < 1 {
+			t.Fatal("First record not found in archive")
+		}
+		bad := binary.AppendUvarint(bytes.Clone(archive[:i]), 1<<30)
+		bad = append(bad, "short"...)
+
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		ar, err := chirpstore.NewArchiveReader(bytes.NewReader(bad))
+		if err != nil {
+			t.Fatalf("NewArchiveReader: unexpected error: %v", err)
+		}
+		if _, _, err := ar.Next(); !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("Next: got %v, want %v", err, io.ErrUnexpectedEOF)
+		}
+		runtime.ReadMemStats(&after)
+		if n := after.TotalAlloc - before.TotalAlloc; n > 1<<20 {
+			t.Errorf("Next allocated %d bytes, want at most %d", n, 1<<20)
+		}
+	})
+
+	t.Run("Resume", func(t *testing.T) {
+		for _, cut := range []int{0, 5, len(archive) / 3, len(archive) - 1, len(archive)} {
+			path := filepath.Join(t.TempDir(), "archive.csar")
+			if err := os.WriteFile(path, archive[:cut], 0600); err != nil {
+				t.Fatalf("Write partial archive: %v", err)
+			}
+			f, err := os.OpenFile(path, os.O_RDWR, 0)
+			if err != nil {
+				t.Fatalf("Open: %v", err)
+			}
+			aw, err := chirpstore.ResumeArchive(f)
+			if err != nil {
+				t.Fatalf("ResumeArchive at %d: unexpected error: %v", cut, err)
+			}
+			last, _ := aw.LastKey()
+			t.Logf("Resume at offset %d: %d records, last key %q", cut, aw.Len(), last)
+
+			if _, err := chirpstore.Export(t.Context(), kv, aw); err != nil {
+				t.Fatalf("Export: unexpected error: %v", err)
+			} else if err := aw.Close(); err != nil {
+				t.Fatalf("Close: unexpected error: %v", err)
+			} else if err := f.Close(); err != nil {
+				t.Fatalf("Close file: unexpected error: %v", err)
+			}
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("Read resumed archive: %v", err)
+			}
+			if !bytes.Equal(data, archive) {
+				t.Errorf("Resumed archive at %d differs from original", cut)
+			}
+		}
+	})
+
+	t.Run("ResumeSafe", func(t *testing.T) {
+		for _, tc := range []struct {
+			name, data string
+			ok         bool
+		}{
+			{"NotArchive", "this is not an archive", false},
+			{"Truncated", string(archive[:3]), false},
+			{"Complete", string(archive), true},
+		} {
+			path := filepath.Join(t.TempDir(), "archive.csar")
+			if err := os.WriteFile(path, []byte(tc.data), 0600); err != nil {
+				t.Fatalf("Write file: %v", err)
+			}
+			f, err := os.OpenFile(path, os.O_RDWR, 0)
+			if err != nil {
+				t.Fatalf("Open: %v", err)
+			}
+			_, err = chirpstore.ResumeArchive(f)
+			f.Close()
+			if ok := err == nil; ok != tc.ok {
+				t.Errorf("ResumeArchive %s: got error %v, want ok=%v", tc.name, err, tc.ok)
+			}
+
+			// The file is not modified until the writer is used.
+			if got, err := os.ReadFile(path); err != nil {
+				t.Fatalf("Read file: %v", err)
+			} else if string(got) != tc.data {
+				t.Errorf("ResumeArchive %s modified the file", tc.name)
+			}
+		}
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		var buf bytes.Buffer
+		aw, err := chirpstore.NewArchiveWriter(&buf)
+		if err != nil {
+			t.Fatalf("NewArchiveWriter: unexpected error: %v", err)
+		}
+		if _, err := chirpstore.Export(t.Context(), memstore.NewKV(), aw); err != nil {
+			t.Fatalf("Export: unexpected error: %v", err)
+		} else if err := aw.Close(); err != nil {
+			t.Fatalf("Close: unexpected error: %v", err)
+		}
+		if got := readArchive(t, &buf); len(got) != 0 {
+			t.Errorf("Empty archive: got %d records, want 0", len(got))
+		}
+	})
+}
+
+func TestImport(t *testing.T) {
+	ctx := t.Context()
+	kv, want := newTestKV(t, 50)
+
+	exportArchive := func(kv blob.KVCore) []byte {
+		t.Helper()
+		var buf bytes.Buffer
+		aw, err := chirpstore.NewArchiveWriter(&buf)
+		if err != nil {
+			t.Fatalf("NewArchiveWriter: unexpected error: %v", err)
+		}
+		if _, err := chirpstore.Export(ctx, kv, aw); err != nil {
+			t.Fatalf("Export: unexpected error: %v", err)
+		} else if err := aw.Close(); err != nil {
+			t.Fatalf("Close: unexpected error: %v", err)
+		}
+		return buf.Bytes()
+	}
+	importArchive := func(kv blob.KV, archive []byte, opts *chirpstore.ImportOptions) (chirpstore.ImportStats, error) {
+		t.Helper()
+		ar, err := chirpstore.NewArchiveReader(bytes.NewReader(archive))
+		if err != nil {
+			t.Fatalf("NewArchiveReader: unexpected error: %v", err)
+		}
+		return chirpstore.Import(ctx, kv, ar, opts)
+	}
+	archive := exportArchive(kv)
+
+	tests := []struct {
+		name    string
+		opts    *chirpstore.ImportOptions
+		want    chirpstore.ImportStats
+		wantErr bool
+	}{
+		{"Default", nil, chirpstore.ImportStats{Written: 48, Skipped: 2}, false},
+		{"Replace", &chirpstore.ImportOptions{Replace: true}, chirpstore.ImportStats{Written: 50}, false},
+		{"VerifyCAS", &chirpstore.ImportOptions{VerifyCAS: true}, chirpstore.ImportStats{Failed: 50}, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			dst := memstore.NewKV().Init(map[string]string{
+				"key-0000": "existing",
+				"key-0017": "existing",
+			})
+			got, err := importArchive(dst, archive, tc.opts)
+			if (err != nil) != tc.wantErr {
+				t.Errorf("Import: got err=%v, want error %v", err, tc.wantErr)
+			}
+			if diff := cmp.Diff(got, tc.want); diff != "" {
+				t.Errorf("Import stats (-got, +want):\n%s", diff)
+			}
+			if tc.wantErr {
+				return
+			}
+			snap := dst.Snapshot(nil)
+			for key, val := range want {
+				if snap[key] != val && snap[key] != "existing" {
+					t.Errorf("Key %q: got %q, want %q", key, snap[key], val)
+				}
+			}
+		})
+	}
+
+	t.Run("CAS", func(t *testing.T) {
+		src := blob.CASFromKV(memstore.NewKV())
+		for i := range 10 {
+			if _, err := src.CASPut(ctx, fmt.Appendf(nil, "blob %d", i)); err != nil {
+				t.Fatalf("CASPut: unexpected error: %v", err)
+			}
+		}
+		got, err := importArchive(memstore.NewKV(), exportArchive(src), &chirpstore.ImportOptions{VerifyCAS: true})
+		if err != nil {
+			t.Errorf("Import: unexpected error: %v", err)
+		}
+		if diff := cmp.Diff(got, chirpstore.ImportStats{Written: 10}); diff != "" {
+			t.Errorf("Import stats (-got, +want):\n%s", diff)
+		}
+	})
+}
@@ -0,0 +1,149 @@
+package chirpstore
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// maxBatchKeys is the number of distinct keys at which a pending batch is sent
+// without waiting for the rest of its window.
+const maxBatchKeys = 256
+
+// A batcher merges concurrent Has and Get calls on a keyspace into a single
+// call to the service. Each kind of call has at most one pending batch, which
+// collects keys until its window expires or it fills up.
+type batcher struct {
+	window time.Duration
+
+	μ   sync.Mutex
+	has *batch // pending has batch, or nil
+	get *batch // pending get batch, or nil
+}
+
+// A batch is a set of keys to be fetched in a single call.
+type batch struct {
+	keys  []string
+	index map[string]int // key to offset in keys
+	refs  []int          // number of callers waiting for each key
+
+	done chan struct{} // closed when the results are ready
+	has  HasResponse   // for a has batch
+	vals [][]byte      // for a get batch
+	err  error
+}
+
+func newBatcher(window time.Duration) *batcher {
+	if window <= 0 {
+		return nil
+	}
+	return &batcher{window: window}
+}
+
+// add adds keys to the pending batch in *slot, starting a new batch if there
+// is none, and returns the batch along with the offsets of the keys in it.
+// When the batch is full or its window expires, it is sent by calling run.
+func (b *batcher) add(slot **batch, keys []string, run func(*batch)) (*batch, []int) {
+	b.μ.Lock()
+	defer b.μ.Unlock()
+
+	p := *slot
+	if p == nil {
+		p = &batch{index: make(map[string]int), done: make(chan struct{})}
+		*slot = p
+		time.AfterFunc(b.window, func() { b.send(slot, p, run) })
+	}
+	pos := make([]int, len(keys))
+	for i, key := range keys {
+		j, ok := p.index[key]
+		if !ok {
+			j = len(p.keys)
+			p.index[key] = j
+			p.keys = append(p.keys, key)
+			p.refs = append(p.refs, 0)
+		}
+		p.refs[j]++
+		pos[i] = j
+	}
+	if len(p.keys) >= maxBatchKeys {
+		*slot = nil
+		go run(p)
+	}
+	return p, pos
+}
+
+// send runs p if it is still pending in *slot.
+func (b *batcher) send(slot **batch, p *batch, run func(*batch)) {
+	b.μ.Lock()
+	pending := *slot == p
+	if pending {
+		*slot = nil
+	}
+	b.μ.Unlock()
+	if pending {
+		run(p)
+	}
+}
+
+// wait blocks until p is done or ctx ends.
+func (p *batch) wait(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-p.done:
+		return p.err
+	}
+}
+
+// batchHas reports which of keys are present, by way of a has batch.
+func (s KV) batchHas(ctx context.Context, keys []string) ([]bool, error) {
+	p, pos := s.batch.add(&s.batch.has, keys, func(p *batch) {
+		defer close(p.done)
+
+		// The batch serves many callers, so it does not obey any one of their
+		// contexts; each caller stops waiting when its own context ends.
+		rsp, err := s.callHas(context.Background(), p.keys)
+		p.has, p.err = rsp, err
+	})
+	if err := p.wait(ctx); err != nil {
+		return nil, err
+	}
+	out := make([]bool, len(keys))
+	for i, j := range pos {
+		out[i] = p.has.IsSet(j)
+	}
+	return out, nil
+}
+
+// batchGet fetches the value of key, by way of a get batch. It reports a nil
+// value if key is not found.
+func (s KV) batchGet(ctx context.Context, key string) ([]byte, error) {
+	p, pos := s.batch.add(&s.batch.get, []string{key}, func(p *batch) {
+		defer close(p.done)
+		rsp, err := s.call(context.Background(), mMGet, MultiGetRequest{
+			ID:   s.spaceID,
+			Keys: p.keys,
+		}.Encode(), true)
+		if err != nil {
+			p.err = unfilterErr(err)
+			return
+		}
+		var mrsp MultiGetResponse
+		if err := mrsp.Decode(rsp.Data); err != nil {
+			p.err = err
+		} else if len(mrsp.Values) != len(p.keys) {
+			p.err = fmt.Errorf("mget: got %d results, want %d", len(mrsp.Values), len(p.keys))
+		} else {
+			p.vals = mrsp.Values
+		}
+	})
+	if err := p.wait(ctx); err != nil {
+		return nil, err
+	}
+	if j := pos[0]; p.refs[j] > 1 {
+		return bytes.Clone(p.vals[j]), nil // don't share with other callers
+	}
+	return p.vals[pos[0]], nil
+}
@@ -0,0 +1,107 @@
+package chirpstore_test
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/creachadair/chirp"
+	"github.com/creachadair/chirpstore"
+	"github.com/creachadair/ffs/blob"
+	"github.com/creachadair/ffs/blob/storetest"
+	"github.com/creachadair/taskgroup"
+)
+
+func TestBatch(t *testing.T) {
+	ctx := t.Context()
+
+	t.Run("Store", func(t *testing.T) {
+		rs := chirpstore.NewStore(newTestService(t, nil), &chirpstore.StoreOptions{
+			BatchWindow: time.Millisecond,
+		})
+		storetest.Run(t, rs)
+	})
+
+	t.Run("Coalesce", func(t *testing.T) {
+		var calls atomic.Int64
+		rs := chirpstore.NewStore(newTestService(t, nil), &chirpstore.StoreOptions{
+			BatchWindow: 50 * time.Millisecond,
+			PacketLogger: func(pkt chirp.Packet, dir chirp.PacketDir) {
+				if dir == chirp.Send && pkt.Type == chirp.PacketRequest {
+					calls.Add(1)
+				}
+			},
+		})
+		kv := mustKV(t, rs, "batch")
+		for i := range 10 {
+			key := fmt.Sprintf("key-%d", i)
+			if err := kv.Put(ctx, blob.PutOptions{Key: key, Data: []byte(key)}); err != nil {
+				t.Fatalf("Put %q: unexpected error: %v", key, err)
+			}
+		}
+
+		const numCalls = 20
+		checkCalls := func(name string) {
+			t.Helper()
+			if n := calls.Swap(0); n >= numCalls {
+				t.Errorf("%s: got %d calls to the service, want fewer than %d", name, n, numCalls)
+			} else {
+				t.Logf("%s: %d concurrent calls sent as %d", name, numCalls, n)
+			}
+		}
+
+		calls.Store(0)
+		var start sync.WaitGroup
+		start.Add(1)
+		g := taskgroup.New(nil)
+		for i := range numCalls {
+			g.Go(func() error {
+				start.Wait()
+				present := fmt.Sprintf("key-%d", i%10)
+				absent := fmt.Sprintf("nonesuch-%d", i)
+				got, err := kv.Has(ctx, present, absent)
+				if err != nil {
+					return err
+				} else if !got.Has(present) || got.Has(absent) {
+					return fmt.Errorf("Has(%q, %q): got %v", present, absent, got)
+				}
+				return nil
+			})
+		}
+		start.Done()
+		if err := g.Wait(); err != nil {
+			t.Errorf("Has: %v", err)
+		}
+		checkCalls("Has")
+
+		start.Add(1)
+		g = taskgroup.New(nil)
+		for i := range numCalls {
+			g.Go(func() error {
+				start.Wait()
+				key := fmt.Sprintf("key-%d", i%10)
+				if i%3 == 0 {
+					key = fmt.Sprintf("nonesuch-%d", i)
+					if got, err := kv.Get(ctx, key); !blob.IsKeyNotFound(err) {
+						return fmt.Errorf("Get %q: got (%q, %v), want %v", key, got, err, blob.ErrKeyNotFound)
+					}
+					return nil
+				}
+				got, err := kv.Get(ctx, key)
+				if err != nil {
+					return fmt.Errorf("Get %q: %w", key, err)
+				} else if string(got) != key {
+					return fmt.Errorf("Get %q: got %q, want %q", key, got, key)
+				}
+				return nil
+			})
+		}
+		start.Done()
+		if err := g.Wait(); err != nil {
+			t.Errorf("Get: %v", err)
+		}
+		checkCalls("Get")
+	})
+}
@@ -0,0 +1,104 @@
+package chirpstore
+
+import (
+	"bytes"
+	"sync"
+	"sync/atomic"
+
+	"github.com/creachadair/mds/cache"
+)
+
+// readCache is a size-bounded LRU cache of values returned by Get, shared by
+// all the keyspaces of a [Store].
+type readCache struct {
+	c            *cache.Cache[cacheKey, []byte]
+	hits, misses atomic.Int64
+
+	// Each invalidation advances the generation, so that a value fetched
+	// before an invalidation is not cached after it (see putIf).
+	μ   sync.Mutex
+	gen uint64
+}
+
+// cacheKey identifies a key within a keyspace.
+type cacheKey struct {
+	id  int
+	key string
+}
+
+func newReadCache(size int64) *readCache {
+	return &readCache{c: cache.New(cache.LRU[cacheKey, []byte]().
+		WithLimit(size).
+		WithSizeFunc(cache.Length),
+	)}
+}
+
+// get returns a copy of the cached value of key in keyspace id, if present.
+func (c *readCache) get(id int, key string) ([]byte, bool) {
+	data, ok := c.c.Get(cacheKey{id, key})
+	if ok {
+		c.hits.Add(1)
+		return bytes.Clone(data), true
+	}
+	c.misses.Add(1)
+	return nil, false
+}
+
+// generation reports the current generation of c. A value fetched after this
+// call may be cached by passing the result to putIf.
+func (c *readCache) generation() uint64 {
+	c.μ.Lock()
+	defer c.μ.Unlock()
+	return c.gen
+}
+
+// putIf records a copy of data as the value of key in keyspace id, unless any
+// value has been invalidated since generation gen. In that case the value may
+// be stale, and is not recorded.
+func (c *readCache) putIf(gen uint64, id int, key string, data []byte) {
+	c.μ.Lock()
+	defer c.μ.Unlock()
+	if c.gen == gen {
+		c.c.Put(cacheKey{id, key}, bytes.Clone(data))
+	}
+}
+
+// remove discards the value of key in keyspace id, if present.
+func (c *readCache) remove(id int, key string) {
+	c.μ.Lock()
+	defer c.μ.Unlock()
+	c.gen++
+	c.c.Remove(cacheKey{id, key})
+}
+
+// clear discards all cached values.
+func (c *readCache) clear() {
+	c.μ.Lock()
+	defer c.μ.Unlock()
+	c.gen++
+	c.c.Clear()
+}
+
+// CacheStats report the activity of the read cache of a [Store].
+type CacheStats struct {
+	Hits    int64 // calls to Get satisfied by the cache
+	Misses  int64 // calls to Get that consulted the service
+	Entries int   // values currently in the cache
+	Size    int64 // total bytes of values currently in the cache
+}
+
+// CacheStats reports the activity of the read cache of s, which is shared
+// among s and all its substores. If the cache is not enabled (see
+// [StoreOptions]), it reports zero.
+func (s Store) CacheStats() CacheStats {
+	c := s.DB.cache
+	if c == nil {
+		return CacheStats{}
+	}
+	return CacheStats{
+		Hits:    c.hits.Load(),
+		Misses:  c.misses.Load(),
+		Entries: c.c.Len(),
+		Size:    c.c.Size(),
+	}
+}
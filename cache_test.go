@@ -0,0 +1,141 @@
+package chirpstore_test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+
+	"github.com/creachadair/chirpstore"
+	"github.com/creachadair/ffs/blob"
+	"github.com/creachadair/ffs/blob/memstore"
+	"github.com/creachadair/ffs/blob/storetest"
+)
+
+// gateStore is a blob.Store whose keyspaces pause each Get after reading the
+// value, while the gate is set, until released.
+type gateStore struct {
+	blob.Store
+	gate              atomic.Bool
+	fetched, released chan struct{}
+}
+
+func (g *gateStore) KV(ctx context.Context, name string) (blob.KV, error) {
+	kv, err := g.Store.KV(ctx, name)
+	return gateKV{KV: kv, g: g}, err
+}
+
+type gateKV struct {
+	blob.KV
+	g *gateStore
+}
+
+func (k gateKV) Get(ctx context.Context, key string) ([]byte, error) {
+	data, err := k.KV.Get(ctx, key)
+	if k.g.gate.Load() {
+		k.g.fetched <- struct{}{}
+		<-k.g.released
+	}
+	return data, err
+}
+
+func TestCache(t *testing.T) {
+	ctx := t.Context()
+
+	t.Run("Store", func(t *testing.T) {
+		rs := chirpstore.NewStore(newTestService(t, nil), &chirpstore.StoreOptions{
+			CacheSize:         1 << 20,
+			CacheAllKeyspaces: true,
+		})
+		storetest.Run(t, rs)
+	})
+
+	t.Run("CAS", func(t *testing.T) {
+		peer := newTestService(t, nil)
+		rs := chirpstore.NewStore(peer, &chirpstore.StoreOptions{CacheSize: 1 << 20})
+		other := mustKV(t, chirpstore.NewStore(peer, nil), "cas")
+
+		cas, err := rs.CAS(ctx, "cas")
+		if err != nil {
+			t.Fatalf("CAS: unexpected error: %v", err)
+		}
+		checkStats := func(want chirpstore.CacheStats) {
+			t.Helper()
+			if got := rs.CacheStats(); got != want {
+				t.Errorf("CacheStats: got %+v, want %+v", got, want)
+			}
+		}
+		checkGet := func(kv blob.KVCore, key, want string) {
+			t.Helper()
+			if got, err := kv.Get(ctx, key); err != nil {
+				t.Errorf("Get %q: unexpected error: %v", key, err)
+			} else if string(got) != want {
+				t.Errorf("Get %q: got %q, want %q", key, got, want)
+			}
+		}
+
+		key, err := cas.CASPut(ctx, []byte("hello"))
+		if err != nil {
+			t.Fatalf("CASPut: unexpected error: %v", err)
+		}
+		checkGet(cas, key, "hello")
+		checkStats(chirpstore.CacheStats{Misses: 1, Entries: 1, Size: 5})
+
+		// A second read is served from the cache, even after another client
+		// has deleted the key.
+		if err := other.Delete(ctx, key); err != nil {
+			t.Fatalf("Delete: unexpected error: %v", err)
+		}
+		checkGet(cas, key, "hello")
+		checkStats(chirpstore.CacheStats{Hits: 1, Misses: 1, Entries: 1, Size: 5})
+
+		// Writing through the store invalidates the cache.
+		if err := cas.Delete(ctx, key); !blob.IsKeyNotFound(err) {
+			t.Errorf("Delete: got %v, want %v", err, blob.ErrKeyNotFound)
+		}
+		checkStats(chirpstore.CacheStats{Hits: 1, Misses: 1})
+		if got, err := cas.Get(ctx, key); !blob.IsKeyNotFound(err) {
+			t.Errorf("Get: got (%q, %v), want %v", got, err, blob.ErrKeyNotFound)
+		}
+
+		// A keyspace opened with KV does not use the cache by default.
+		kv := mustKV(t, rs, "cas")
+		if err := kv.Put(ctx, blob.PutOptions{Key: "plain", Data: []byte("value")}); err != nil {
+			t.Fatalf("Put: unexpected error: %v", err)
+		}
+		checkGet(kv, "plain", "value")
+		checkGet(kv, "plain", "value")
+		checkStats(chirpstore.CacheStats{Hits: 1, Misses: 2})
+	})
+
+	t.Run("Race", func(t *testing.T) {
+		gs := &gateStore{
+			Store:    memstore.New(nil),
+			fetched:  make(chan struct{}),
+			released: make(chan struct{}),
+		}
+		kv := mustKV(t, chirpstore.NewStore(newTestService(t, chirpstore.NewService(gs, nil)), &chirpstore.StoreOptions{
+			CacheSize:         1 << 20,
+			CacheAllKeyspaces: true,
+		}), "race")
+		if err := kv.Put(ctx, blob.PutOptions{Key: "k", Data: []byte("old")}); err != nil {
+			t.Fatalf("Put: unexpected error: %v", err)
+		}
+
+		// Start a read that fetches the old value, then overwrite it before the
+		// read completes. The old value must not be cached afterward.
+		gs.gate.Store(true)
+		done := make(chan struct{})
+		go func() { defer close(done); kv.Get(ctx, "k") }()
+		<-gs.fetched
+		gs.gate.Store(false)
+		if err := kv.Put(ctx, blob.PutOptions{Key: "k", Data: []byte("new"), Replace: true}); err != nil {
+			t.Fatalf("Put: unexpected error: %v", err)
+		}
+		close(gs.released)
+		<-done
+
+		if got, err := kv.Get(ctx, "k"); err != nil || string(got) != "new" {
+			t.Errorf("Get: got (%q, %v), want new", got, err)
+		}
+	})
+}
@@ -0,0 +1,105 @@
+package chirpstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+
+	"github.com/creachadair/chirp"
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// sum returns the checksum of data of kind c.
+func (c Checksum) sum(data []byte) ([]byte, error) {
+	switch c {
+	case ChecksumNone:
+		return nil, nil
+	case ChecksumCRC32C:
+		return binary.BigEndian.AppendUint32(nil, crc32.Checksum(data, castagnoli)), nil
+	case ChecksumSHA256:
+		h := sha256.Sum256(data)
+		return h[:], nil
+	default:
+		return nil, fmt.Errorf("unknown checksum %v", c)
+	}
+}
+
+// verify reports whether sum is the checksum of kind c for the value of key,
+// which is data. If not, it reports a [*ChecksumError].
+func (c Checksum) verify(key string, sum, data []byte) error {
+	want, err := c.sum(data)
+	if err != nil {
+		return err
+	} else if !bytes.Equal(sum, want) {
+		return &ChecksumError{Key: key}
+	}
+	return nil
+}
+
+// wireSum is the client state for checking values sent to and from a service.
+// Whether the service supports the checksum is negotiated on first use and
+// shared by all the keyspaces of a store.
+type wireSum struct {
+	kind Checksum
+	feature
+}
+
+func newWireSum(kind Checksum) *wireSum {
+	if kind == ChecksumNone {
+		return nil
+	}
+	return &wireSum{kind: kind}
+}
+
+// ready reports whether checksums are enabled for s, negotiating with the
+// service if that has not yet been done.
+func (w *wireSum) ready(ctx context.Context, s KV) bool {
+	return w != nil && w.check(ctx, s, mChecksums, byte(w.kind))
+}
+
+// Checksums reports the checksums the service accepts in put and cget
+// requests. The response is a list of [Checksum] values, one per byte.
+func (s *Service) Checksums(ctx context.Context, req *chirp.Request) ([]byte, error) {
+	if len(req.Data) != 0 {
+		return nil, errors.New("no parameters accepted")
+	}
+	return []byte{byte(ChecksumNone), byte(ChecksumCRC32C), byte(ChecksumSHA256)}, nil
+}
+
+// CheckedGet handles the corresponding method of [blob.KV], for a client that
+// checks the values it receives. The request is a [CheckedGetRequest], and the
+// response is a [CodedValue] carrying the requested checksum of the plain
+// value. The value is compressed only if the client accepts that, as for
+// [Service.ZGet].
+func (s *Service) CheckedGet(ctx context.Context, req *chirp.Request) ([]byte, error) {
+	var creq CheckedGetRequest
+	if err := creq.Decode(req.Data); err != nil {
+		return nil, err
+	}
+	kv := s.idToKV(creq.ID)
+	if kv == nil {
+		return invalidKeyspaceID(creq.ID)
+	}
+	codec, data, err := s.loadValue(ctx, kv, string(creq.Key))
+	if err != nil {
+		return nil, filterErr(err)
+	}
+	plain, err := decodeValue(codec, data, 0)
+	if err != nil {
+		return nil, err
+	}
+	sum, err := creq.Checksum.sum(plain)
+	if err != nil {
+		return nil, err
+	}
+	v := CodedValue{Data: plain, Checksum: creq.Checksum, Sum: sum}
+	if creq.Compress {
+		v.Codec, v.Data = s.compressValue(codec, data)
+	}
+	return v.Encode(), nil
+}
@@ -0,0 +1,164 @@
+package chirpstore_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/creachadair/chirp"
+	"github.com/creachadair/chirp/channel"
+	"github.com/creachadair/chirpstore"
+	"github.com/creachadair/ffs/blob"
+	"github.com/creachadair/ffs/blob/memstore"
+	"github.com/creachadair/ffs/blob/storetest"
+)
+
+// flakyChannel is a chirp.Channel that corrupts the last byte of each packet
+// it sends while corrupt is set.
+type flakyChannel struct {
+	chirp.Channel
+	corrupt atomic.Bool
+}
+
+func (c *flakyChannel) Send(pkt chirp.Packet) error {
+	if c.corrupt.Load() && len(pkt.Payload) != 0 {
+		p := append([]byte(nil), pkt.Payload...)
+		p[len(p)-1] ^= 0x55
+		pkt.Payload = p
+	}
+	return c.Channel.Send(pkt)
+}
+
+// corruptService returns a peer for a service over st, along with the channels
+// by which the client sends requests and the service sends responses.
+func corruptService(t *testing.T, st blob.Store, opts *chirpstore.ServiceOptions) (*chirp.Peer, *flakyChannel, *flakyChannel) {
+	t.Helper()
+	a2b, b2a := channel.Direct()
+	out, in := &flakyChannel{Channel: b2a}, &flakyChannel{Channel: a2b}
+
+	svc := new(chirp.Peer).Detach().Start(in)
+	chirpstore.NewService(st, opts).Register(svc)
+	cli := new(chirp.Peer).Detach().Start(out)
+	t.Cleanup(func() { cli.Stop(); svc.Stop() })
+	return cli, out, in
+}
+
+func TestChecksum(t *testing.T) {
+	ctx := t.Context()
+
+	t.Run("Store", func(t *testing.T) {
+		peer, _, _ := corruptService(t, memstore.New(nil), &chirpstore.ServiceOptions{StoreCompressed: true})
+		storetest.Run(t, chirpstore.NewStore(peer, &chirpstore.StoreOptions{
+			Checksum:    chirpstore.ChecksumSHA256,
+			Compression: &chirpstore.CompressionOptions{MinSize: 64},
+		}))
+	})
+
+	t.Run("Corrupt", func(t *testing.T) {
+		mem := memstore.New(nil)
+		peer, out, in := corruptService(t, mem, nil)
+		kv := mustKV(t, chirpstore.NewStore(peer, &chirpstore.StoreOptions{
+			Checksum: chirpstore.ChecksumCRC32C,
+		}), "data")
+		raw := mustKVAny(t, mem, "data")
+
+		// The first call negotiates checksums over a clean channel.
+		if err := kv.Put(ctx, blob.PutOptions{Key: "good", Data: []byte("intact")}); err != nil {
+			t.Fatalf("Put: unexpected error: %v", err)
+		}
+		if got := kv.Checksum(ctx); got != chirpstore.ChecksumCRC32C {
+			t.Errorf("Checksum: got %v, want %v", got, chirpstore.ChecksumCRC32C)
+		}
+		checkMismatch := func(t *testing.T, op string, err error, key string) {
+			t.Helper()
+			ce, ok := errors.AsType[*chirpstore.ChecksumError](err)
+			if !ok || ce.Key != key || !errors.Is(err, chirpstore.ErrChecksumMismatch) {
+				t.Errorf("%s: got %v, want checksum mismatch for %q", op, err, key)
+			}
+		}
+
+		// A value corrupted on the way to the service is not stored.
+		out.corrupt.Store(true)
+		err := kv.Put(ctx, blob.PutOptions{Key: "bad", Data: []byte("mangled")})
+		out.corrupt.Store(false)
+		checkMismatch(t, "Put", err, "bad")
+		if got, err := raw.Get(ctx, "bad"); !blob.IsKeyNotFound(err) {
+			t.Errorf("Get stored: got (%q, %v), want key not found", got, err)
+		}
+
+		// A value corrupted on the way to the client is not returned.
+		in.corrupt.Store(true)
+		got, err := kv.Get(ctx, "good")
+		in.corrupt.Store(false)
+		checkMismatch(t, "Get", err, "good")
+		if got != nil {
+			t.Errorf("Get: got %q, want no value", got)
+		}
+
+		if got, err := kv.Get(ctx, "good"); err != nil || string(got) != "intact" {
+			t.Errorf("Get: got (%q, %v), want intact", got, err)
+		}
+	})
+
+	t.Run("Batch", func(t *testing.T) {
+		// Get is not batched while checksums are in effect, so that a corrupted
+		// value is still detected.
+		peer, _, in := corruptService(t, memstore.New(nil), nil)
+		kv := mustKV(t, chirpstore.NewStore(peer, &chirpstore.StoreOptions{
+			Checksum:    chirpstore.ChecksumCRC32C,
+			BatchWindow: time.Millisecond,
+		}), "data")
+		if err := kv.Put(ctx, blob.PutOptions{Key: "good", Data: []byte("intact")}); err != nil {
+			t.Fatalf("Put: unexpected error: %v", err)
+		}
+		in.corrupt.Store(true)
+		got, err := kv.Get(ctx, "good")
+		in.corrupt.Store(false)
+		if !errors.Is(err, chirpstore.ErrChecksumMismatch) {
+			t.Errorf("Get: got (%q, %v), want checksum mismatch", got, err)
+		}
+	})
+
+	t.Run("Unsupported", func(t *testing.T) {
+		// A service without the checksums method gets values without them.
+		kv := mustKV(t, chirpstore.NewStore(oldService(t, memstore.New(nil)), &chirpstore.StoreOptions{
+			Checksum: chirpstore.ChecksumSHA256,
+		}), "data")
+		if err := kv.Put(ctx, blob.PutOptions{Key: "k", Data: []byte("v")}); err != nil {
+			t.Fatalf("Put: unexpected error: %v", err)
+		}
+		if got, err := kv.Get(ctx, "k"); err != nil || string(got) != "v" {
+			t.Errorf("Get: got (%q, %v), want v", got, err)
+		}
+		if got := kv.Checksum(ctx); got != chirpstore.ChecksumNone {
+			t.Errorf("Checksum: got %v, want %v", got, chirpstore.ChecksumNone)
+		}
+	})
+
+	t.Run("Negotiate", func(t *testing.T) {
+		// A service error other than an unknown method does not settle whether
+		// checksums are supported.
+		var reject atomic.Bool
+		reject.Store(true)
+		peer, _, _ := corruptService(t, memstore.New(nil), &chirpstore.ServiceOptions{
+			Authorize: func(_ context.Context, method string) error {
+				if method == "checksums" && reject.Load() {
+					return errors.New("not now")
+				}
+				return nil
+			},
+		})
+		kv := mustKV(t, chirpstore.NewStore(peer, &chirpstore.StoreOptions{
+			Checksum: chirpstore.ChecksumSHA256,
+		}), "data")
+		if got := kv.Checksum(ctx); got != chirpstore.ChecksumNone {
+			t.Errorf("Checksum rejected: got %v, want %v", got, chirpstore.ChecksumNone)
+		}
+		reject.Store(false)
+		if got := kv.Checksum(ctx); got != chirpstore.ChecksumSHA256 {
+			t.Errorf("Checksum: got %v, want %v", got, chirpstore.ChecksumSHA256)
+		}
+	})
+}
@@ -1,11 +1,15 @@
 package chirpstore_test
 
 import (
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"strings"
 	"testing"
 
+	"github.com/google/go-cmp/cmp"
+
 	"github.com/creachadair/chirp"
 	"github.com/creachadair/chirp/peers"
 	"github.com/creachadair/chirpstore"
@@ -15,7 +19,11 @@ import (
 )
 
 // Interface satisfaction checks.
-var _ blob.KV = chirpstore.KV{}
+var (
+	_ blob.KV               = chirpstore.KV{}
+	_ blob.StoreCloser      = chirpstore.Store{}
+	_ chirpstore.NameLister = chirpstore.Store{}
+)
 
 var doDebug = flag.Bool("debug", false, "Enable debug logging")
 
@@ -26,10 +34,13 @@ func logPacket(t *testing.T, tag string) chirp.PacketLogger {
 	}
 }
 
-func newTestService(t *testing.T) *chirp.Peer {
-	bs := memstore.New(nil)
-	svc := chirpstore.NewService(bs, nil)
-
+// newTestService registers svc on a local peer, and returns the client peer
+// connected to it. If svc == nil, it serves a new memory store with default
+// options.
+func newTestService(t *testing.T, svc *chirpstore.Service) *chirp.Peer {
+	if svc == nil {
+		svc = chirpstore.NewService(memstore.New(nil), nil)
+	}
 	loc := peers.NewLocal()
 	svc.Register(loc.A)
 	if *doDebug {
@@ -44,13 +55,13 @@ func newTestService(t *testing.T) *chirp.Peer {
 }
 
 func TestStore(t *testing.T) {
-	peer := newTestService(t)
+	peer := newTestService(t, nil)
 	rs := chirpstore.NewStore(peer, nil)
 	storetest.Run(t, rs)
 }
 
 func TestCAS(t *testing.T) {
-	peer := newTestService(t)
+	peer := newTestService(t, nil)
 	rs := chirpstore.NewStore(peer, nil)
 
 	cas := storetest.SubCAS(t, rs, "")
@@ -93,3 +104,217 @@ func TestCAS(t *testing.T) {
 		}
 	})
 }
+
+func TestNames(t *testing.T) {
+	peer := newTestService(t, nil)
+	rs := chirpstore.NewStore(peer, nil)
+	ctx := t.Context()
+
+	checkNames := func(t *testing.T, tag string, list func() ([]string, error), want ...string) {
+		t.Helper()
+		got, err := list()
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tag, err)
+		}
+		if diff := cmp.Diff(got, want); diff != "" {
+			t.Errorf("%s: wrong names (-got, +want):\n%s", tag, diff)
+		}
+	}
+
+	checkNames(t, "KVNames", func() ([]string, error) { return rs.KVNames(ctx) })
+	checkNames(t, "SubNames", func() ([]string, error) { return rs.SubNames(ctx) })
+
+	for _, name := range []string{"foxtrot", "alpha", "echo"} {
+		if _, err := rs.KV(ctx, name); err != nil {
+			t.Fatalf("KV %q: unexpected error: %v", name, err)
+		}
+	}
+	if _, err := rs.CAS(ctx, "bravo"); err != nil {
+		t.Fatalf("CAS: unexpected error: %v", err)
+	}
+	sub, err := rs.Sub(ctx, "delta")
+	if err != nil {
+		t.Fatalf("Sub: unexpected error: %v", err)
+	}
+	if _, err := sub.KV(ctx, "golf"); err != nil {
+		t.Fatalf("KV: unexpected error: %v", err)
+	}
+
+	ss := sub.(chirpstore.Store)
+	checkNames(t, "KVNames", func() ([]string, error) { return rs.KVNames(ctx) },
+		"alpha", "bravo", "echo", "foxtrot")
+	checkNames(t, "SubNames", func() ([]string, error) { return rs.SubNames(ctx) }, "delta")
+	checkNames(t, "Sub/KVNames", func() ([]string, error) { return ss.KVNames(ctx) }, "golf")
+	checkNames(t, "Sub/SubNames", func() ([]string, error) { return ss.SubNames(ctx) })
+}
+
+func TestDrop(t *testing.T) {
+	peer := newTestService(t, nil)
+	rs := chirpstore.NewStore(peer, nil)
+	ctx := t.Context()
+
+	kv := mustKV(t, rs, "target")
+	for _, key := range []string{"a1", "a2", "b1", "b2", "b3", "c1"} {
+		if err := kv.Put(ctx, blob.PutOptions{Key: key, Data: []byte(key)}); err != nil {
+			t.Fatalf("Put %q: unexpected error: %v", key, err)
+		}
+	}
+
+	checkDrop := func(opts chirpstore.DropOptions, want int64, wantKeys ...string) {
+		t.Helper()
+		n, err := kv.Drop(ctx, opts)
+		if err != nil {
+			t.Fatalf("Drop %+v: unexpected error: %v", opts, err)
+		} else if n != want {
+			t.Errorf("Drop %+v: got %d, want %d", opts, n, want)
+		}
+		if diff := cmp.Diff(listKeys(t, kv), wantKeys); diff != "" {
+			t.Errorf("Keys after drop (-got, +want):\n%s", diff)
+		}
+	}
+
+	t.Run("Unconfirmed", func(t *testing.T) {
+		for _, opts := range []chirpstore.DropOptions{
+			{Confirm: true, Keyspace: "wrong"},
+			{Keyspace: "target"},
+		} {
+			if n, err := kv.Drop(ctx, opts); err == nil {
+				t.Errorf("Drop %+v: got %d, want error", opts, n)
+			}
+		}
+	})
+	t.Run("Unnamed", func(t *testing.T) {
+		// The unnamed keyspace can be dropped, but only if confirmed.
+		kv0 := mustKV(t, chirpstore.NewStore(newTestService(t, nil), nil), "")
+		if err := kv0.Put(ctx, blob.PutOptions{Key: "x", Data: []byte("x")}); err != nil {
+			t.Fatalf("Put: unexpected error: %v", err)
+		}
+		if n, err := kv0.Drop(ctx, chirpstore.DropOptions{}); err == nil {
+			t.Errorf("Drop unconfirmed: got %d, want error", n)
+		}
+		if n, err := kv0.Drop(ctx, chirpstore.DropOptions{Confirm: true}); err != nil || n != 1 {
+			t.Errorf("Drop: got (%d, %v), want 1", n, err)
+		}
+	})
+	t.Run("Prefix", func(t *testing.T) {
+		checkDrop(chirpstore.DropOptions{Confirm: true, Keyspace: "target", Prefix: "b"}, 3, "a1", "a2", "c1")
+	})
+	t.Run("NoMatch", func(t *testing.T) {
+		checkDrop(chirpstore.DropOptions{Confirm: true, Keyspace: "target", Prefix: "q"}, 0, "a1", "a2", "c1")
+	})
+	t.Run("All", func(t *testing.T) {
+		checkDrop(chirpstore.DropOptions{Confirm: true, Keyspace: "target"}, 3)
+	})
+	t.Run("Status", func(t *testing.T) {
+		data, err := kv.Status(ctx)
+		if err != nil {
+			t.Fatalf("Status: unexpected error: %v", err)
+		}
+		var status struct {
+			Store struct {
+				Keyspaces int   `json:"keyspaces"`
+				Deleted   int64 `json:"drop_keys_deleted"`
+			} `json:"store"`
+		}
+		if err := json.Unmarshal(data, &status); err != nil {
+			t.Fatalf("Decode status: %v", err)
+		}
+		if got := status.Store.Keyspaces; got != 1 {
+			t.Errorf("Status keyspaces: got %d, want 1", got)
+		}
+		if got := status.Store.Deleted; got != 6 {
+			t.Errorf("Status drop_keys_deleted: got %d, want 6", got)
+		}
+	})
+}
+
+func listKeys(t *testing.T, kv blob.KVCore) []string {
+	t.Helper()
+	var keys []string
+	for key, err := range kv.List(t.Context(), "") {
+		if err != nil {
+			t.Fatalf("List: unexpected error: %v", err)
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func mustKV(t *testing.T, st blob.Store, name string) chirpstore.KV {
+	t.Helper()
+	kv, err := st.KV(t.Context(), name)
+	if err != nil {
+		t.Fatalf("KV %q: unexpected error: %v", name, err)
+	}
+	return kv.(chirpstore.KV)
+}
+
+func TestCopyMove(t *testing.T) {
+	peer := newTestService(t, nil)
+	rs := chirpstore.NewStore(peer, nil)
+	ctx := t.Context()
+
+	staging := mustKV(t, rs, "staging")
+	published := mustKV(t, rs, "published")
+	for _, key := range []string{"a", "b", "c"} {
+		if err := staging.Put(ctx, blob.PutOptions{Key: key, Data: []byte("value " + key)}); err != nil {
+			t.Fatalf("Put %q: unexpected error: %v", key, err)
+		}
+	}
+
+	checkKeys := func(kv chirpstore.KV, want ...string) {
+		t.Helper()
+		if diff := cmp.Diff(listKeys(t, kv), want); diff != "" {
+			t.Errorf("Wrong keys (-got, +want):\n%s", diff)
+		}
+	}
+
+	if n, err := staging.CopyTo(ctx, published, chirpstore.CopyOptions{Keys: []string{"a", "b"}}); err != nil {
+		t.Fatalf("CopyTo: unexpected error: %v", err)
+	} else if n != 2 {
+		t.Errorf("CopyTo: got %d, want 2", n)
+	}
+	checkKeys(staging, "a", "b", "c")
+	checkKeys(published, "a", "b")
+
+	if _, err := staging.CopyTo(ctx, published, chirpstore.CopyOptions{Keys: []string{"b"}}); !blob.IsKeyExists(err) {
+		t.Errorf("CopyTo existing: got %v, want %v", err, blob.ErrKeyExists)
+	}
+	if _, err := staging.CopyTo(ctx, published, chirpstore.CopyOptions{
+		Keys:    []string{"b"},
+		Replace: true,
+	}); err != nil {
+		t.Errorf("CopyTo with replace: unexpected error: %v", err)
+	}
+
+	if n, err := staging.MoveTo(ctx, published, chirpstore.CopyOptions{Keys: []string{"c"}}); err != nil {
+		t.Fatalf("MoveTo: unexpected error: %v", err)
+	} else if n != 1 {
+		t.Errorf("MoveTo: got %d, want 1", n)
+	}
+	checkKeys(staging, "a", "b")
+	checkKeys(published, "a", "b", "c")
+	if got, err := published.Get(ctx, "c"); err != nil || string(got) != "value c" {
+		t.Errorf("Get c: got (%q, %v), want value c", got, err)
+	}
+
+	// A partial move reports the keys moved before the failure.
+	if err := staging.Put(ctx, blob.PutOptions{Key: "d", Data: []byte("value d")}); err != nil {
+		t.Fatalf("Put d: unexpected error: %v", err)
+	}
+	n, err := staging.MoveTo(ctx, published, chirpstore.CopyOptions{Keys: []string{"d", "a", "b"}})
+	if ke, ok := errors.AsType[*blob.KeyError](err); !ok || !blob.IsKeyExists(err) || ke.Key != "a" {
+		t.Errorf("MoveTo partial: got %v, want key exists for a", err)
+	} else if n != 1 {
+		t.Errorf("MoveTo partial: got %d, want 1", n)
+	}
+	checkKeys(staging, "a", "b")
+	checkKeys(published, "a", "b", "c", "d")
+
+	if _, err := staging.MoveTo(ctx, published, chirpstore.CopyOptions{Keys: []string{"nonesuch"}}); !blob.IsKeyNotFound(err) {
+		t.Errorf("MoveTo missing: got %v, want %v", err, blob.ErrKeyNotFound)
+	}
+	if _, err := staging.MoveTo(ctx, staging, chirpstore.CopyOptions{Keys: []string{"a"}}); err == nil {
+		t.Error("MoveTo self: got nil, want error")
+	}
+}
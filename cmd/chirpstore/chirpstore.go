@@ -0,0 +1,261 @@
+// Program chirpstore serves and accesses blob stores over Chirp v0.
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/creachadair/chirp"
+	"github.com/creachadair/chirp/channel"
+	"github.com/creachadair/chirpstore"
+	"github.com/creachadair/command"
+	"github.com/creachadair/ffs/blob"
+	"github.com/creachadair/ffs/blob/memstore"
+	"github.com/creachadair/ffs/storage/filestore"
+	"github.com/creachadair/flax"
+)
+
+func main() {
+	root := &command.C{
+		Name: filepath.Base(os.Args[0]),
+		Help: `Serve and access blob stores over Chirp v0.
+
+The client commands connect to the service at the address given by --addr,
+or run the command given by --cmd and communicate with it over its stdin and
+stdout (see "serve --stdio"). They operate on the keyspace named by --kv in
+the substore named by --sub.`,
+		SetFlags: command.Flags(flax.MustBind, &flags),
+		Commands: append([]*command.C{
+			{
+				Name:  "serve",
+				Usage: "[flags] <address>\n--stdio [flags]",
+				Help: `Serve a blob store at the specified address.
+
+The address may be a host:port for TCP, or a path for a Unix-domain socket.
+Each connection to the address is served by its own peer, but all the peers
+share the same store and keyspace IDs.
+
+With --stdio, the server serves a single peer on stdin and stdout instead of
+listening at an address, and exits when stdin is closed. This allows clients
+to reach a remote store over SSH, for example:
+
+  chirpstore --cmd "ssh host chirpstore serve --stdio" list
+
+With --tls-cert and --tls-key, the server accepts only TLS connections. If
+--client-ca is also set, clients must present a certificate issued by one of
+the CAs in that file.
+
+With --websocket, the server accepts WebSocket connections over HTTP (or HTTPS,
+with TLS), for environments where only HTTP traffic is permitted. Clients
+connect with an address of the form ws://host:port or wss://host:port.
+
+The --store flag selects the backing store:
+
+  memory     : an in-memory store, not persisted (default)
+  file:<dir> : a file-based store rooted at <dir>
+`,
+				SetFlags: command.Flags(flax.MustBind, &serveFlags),
+				Run:      command.Adapt(runServe),
+			}},
+			append(clientCommands(),
+				command.VersionCommand(),
+				command.HelpCommand(nil),
+			)...,
+		),
+	}
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	command.RunOrFail(root.NewEnv(nil).SetContext(ctx), os.Args[1:])
+}
+
+var flags struct {
+	Prefix string `flag:"prefix,Prefix to prepend to service method names"`
+
+	// Client settings.
+	Addr   string `flag:"addr,default=$CHIRPSTORE_ADDR,Service address (host:port, socket path, or ws:// URL)"`
+	Cmd    string `flag:"cmd,Run this command and communicate over its stdin and stdout"`
+	TLS    bool   `flag:"tls,Connect to the service using TLS"`
+	TLSCA  string `flag:"tls-ca,Verify the service with the CAs in this file (PEM; implies --tls)"`
+	Cert   string `flag:"tls-cert,Present this client certificate file (PEM; implies --tls)"`
+	Key    string `flag:"tls-key,Private key file (PEM) for --tls-cert"`
+	Sub    string `flag:"sub,Substore path, names separated by slashes"`
+	KV     string `flag:"kv,Keyspace name within the substore"`
+	Hex    bool   `flag:"x,Keys are encoded in hexadecimal"`
+	Base64 bool   `flag:"b,Keys are encoded in base64"`
+}
+
+var serveFlags struct {
+	Store string `flag:"store,default=memory,Backing store (memory or file:<dir>)"`
+	Debug bool   `flag:"debug,Enable packet logging (warning: noisy)"`
+	Stdio bool   `flag:"stdio,Serve a single peer on stdin and stdout"`
+
+	WebSocket bool `flag:"websocket,Serve WebSocket connections over HTTP"`
+
+	TLSCert  string `flag:"tls-cert,Serve TLS with this certificate file (PEM)"`
+	TLSKey   string `flag:"tls-key,Private key file (PEM) for --tls-cert"`
+	ClientCA string `flag:"client-ca,Require client certificates issued by the CAs in this file (PEM)"`
+
+	Drain time.Duration `flag:"drain,default=30s,How long to wait for calls in flight at shutdown"`
+}
+
+func runServe(env *command.Env, args ...string) (err error) {
+	ctx := env.Context()
+	if serveFlags.Stdio && len(args) != 0 {
+		return env.Usagef("no address is accepted with --stdio")
+	} else if !serveFlags.Stdio && len(args) != 1 {
+		return env.Usagef("exactly one address is required")
+	}
+	bs, err := openStore(serveFlags.Store)
+	if err != nil {
+		return fmt.Errorf("open store: %w", err)
+	}
+
+	opts := &chirpstore.ServiceOptions{Prefix: flags.Prefix}
+	if serveFlags.Debug {
+		lg := log.New(log.Writer(), "[chirpstore] ", log.LstdFlags|log.Lmicroseconds)
+		opts.PacketLogger = func(pkt chirp.Packet, dir chirp.PacketDir) { lg.Printf("%s %v", dir, pkt) }
+	}
+	svc := chirpstore.NewService(bs, opts)
+
+	// However the server exits, shut down the service so that the store is
+	// closed. If a signal began a drain, this waits for it to finish.
+	defer func() {
+		dctx, cancel := context.WithTimeout(context.Background(), serveFlags.Drain)
+		defer cancel()
+		if serr := svc.Shutdown(dctx); serr != nil {
+			log.Printf("Shutdown: %v", serr)
+			if err == nil {
+				err = serr
+			}
+		}
+	}()
+
+	// When a signal arrives, drain the service before stopping the peers, so
+	// that calls in flight are allowed to finish. Shutdown closes the store.
+	sctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancel()
+	stop := context.AfterFunc(ctx, func() {
+		defer cancel()
+		log.Printf("Draining service (timeout %v)", serveFlags.Drain)
+		dctx, cancel := context.WithTimeout(context.Background(), serveFlags.Drain)
+		defer cancel()
+		if err := svc.Shutdown(dctx); err != nil {
+			log.Printf("Shutdown: %v", err)
+		}
+	})
+	defer stop()
+
+	if serveFlags.Stdio {
+		// Log messages go to stderr, so they do not interfere with the peer.
+		log.Printf("Serving %s on stdin/stdout", serveFlags.Store)
+		err = svc.ServeChannel(sctx, channel.IO(os.Stdin, os.Stdout))
+	} else {
+		ntype, addr := chirp.SplitAddress(args[0])
+		lst, lerr := net.Listen(ntype, addr)
+		if lerr != nil {
+			return lerr
+		}
+		if ntype == "unix" {
+			defer os.Remove(addr) // clean up the socket
+		}
+		defer lst.Close()
+		var config *tls.Config
+		if serveFlags.TLSCert != "" {
+			config, err = serverTLSConfig()
+			if err != nil {
+				return err
+			}
+		}
+		log.Printf("Serving %s at %s %q (tls=%v, websocket=%v)",
+			serveFlags.Store, ntype, addr, config != nil, serveFlags.WebSocket)
+		switch {
+		case serveFlags.WebSocket:
+			err = serveWebSocket(sctx, svc, lst, config)
+		case config != nil:
+			err = svc.ServeTLS(sctx, lst, config)
+		default:
+			err = svc.Serve(sctx, lst)
+		}
+	}
+	log.Printf("Server exited (err=%v)", err)
+	return err
+}
+
+// serveWebSocket serves WebSocket connections to svc over HTTP on lst until
+// ctx ends. If config != nil, the HTTP server uses TLS.
+func serveWebSocket(ctx context.Context, svc *chirpstore.Service, lst net.Listener, config *tls.Config) error {
+	srv := &http.Server{
+		Handler:   svc.WebSocketHandler(nil),
+		TLSConfig: config,
+
+		// The peers for hijacked connections outlive the server, so give them
+		// contexts that end with ctx.
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+	stop := context.AfterFunc(ctx, func() { srv.Close() })
+	defer stop()
+
+	var err error
+	if config != nil {
+		err = srv.ServeTLS(lst, "", "")
+	} else {
+		err = srv.Serve(lst)
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// serverTLSConfig constructs a TLS config from the serve flags.
+func serverTLSConfig() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(serveFlags.TLSCert, serveFlags.TLSKey)
+	if err != nil {
+		return nil, fmt.Errorf("load certificate: %w", err)
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{cert}}
+	if serveFlags.ClientCA != "" {
+		pool, err := loadCertPool(serveFlags.ClientCA)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+		config.ClientCAs = pool
+	}
+	return config, nil
+}
+
+// loadCertPool reads a pool of CA certificates from a PEM file.
+func loadCertPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in %q", path)
+	}
+	return pool, nil
+}
+
+// openStore opens a blob store from a --store flag specification.
+func openStore(spec string) (blob.StoreCloser, error) {
+	if spec == "memory" {
+		return memstore.New(nil), nil
+	} else if dir, ok := strings.CutPrefix(spec, "file:"); ok && dir != "" {
+		return filestore.New(dir)
+	}
+	return nil, fmt.Errorf("unknown store %q", spec)
+}
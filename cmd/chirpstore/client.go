@@ -0,0 +1,495 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/creachadair/chirp"
+	"github.com/creachadair/chirp/channel"
+	"github.com/creachadair/chirpstore"
+	"github.com/creachadair/command"
+	"github.com/creachadair/ffs/blob"
+	"github.com/creachadair/flax"
+)
+
+// clientCommands returns the commands that operate on a remote store.
+func clientCommands() []*command.C {
+	return []*command.C{
+		{
+			Name:  "get",
+			Usage: "<key>",
+			Help:  "Write the value of a key to stdout.",
+			Run:   command.Adapt(runGet),
+		},
+		{
+			Name:  "put",
+			Usage: "<key> [<file>]",
+			Help: `Write the value of a key.
+
+The value is read from the specified file, or from stdin if the file is
+omitted or is "-".`,
+			SetFlags: command.Flags(flax.MustBind, &putFlags),
+			Run:      command.Adapt(runPut),
+		},
+		{
+			Name:  "has",
+			Usage: "<key> ...",
+			Help:  "Report whether each of the specified keys is present.",
+			Run:   command.Adapt(runHas),
+		},
+		{
+			Name:  "delete",
+			Usage: "<key> ...",
+			Help:  "Delete the specified keys.",
+			Run:   command.Adapt(runDelete),
+		},
+		{
+			Name:     "list",
+			Usage:    "[<start>]",
+			Help:     "List keys in order, beginning at or after start.",
+			SetFlags: command.Flags(flax.MustBind, &listFlags),
+			Run:      command.Adapt(runList),
+		},
+		{
+			Name: "len",
+			Help: "Report the number of keys in the keyspace.",
+			Run:  command.Adapt(runLen),
+		},
+		{
+			Name: "status",
+			Help: "Print the status metrics of the service.",
+			Run:  command.Adapt(runStatus),
+		},
+		{
+			Name: "names",
+			Help: "List the names of the keyspaces and substores in the substore.",
+			Run:  command.Adapt(runNames),
+		},
+		{
+			Name:  "export",
+			Usage: "<file>",
+			Help: `Export the contents of the keyspace to an archive file.
+
+By default, the file must not already exist. With --resume, if the file
+already contains a partial archive, the export resumes after the last key it
+contains. With --force, an existing file is replaced.`,
+			SetFlags: command.Flags(flax.MustBind, &exportFlags),
+			Run:      command.Adapt(runExport),
+		},
+		{
+			Name:     "import",
+			Usage:    "<file>",
+			Help:     "Import the contents of an archive file into the keyspace.",
+			SetFlags: command.Flags(flax.MustBind, &importFlags),
+			Run:      command.Adapt(runImport),
+		},
+		{
+			Name:  "http",
+			Usage: "<address>",
+			Help: `Serve an HTTP gateway to the substore at the given address.
+
+Requests have paths of the form /{sub...}/{keyspace}/{key}, relative to the
+substore selected by --sub. GET, HEAD, PUT, and DELETE operate on the key, and
+GET with an empty key lists the keyspace. Add ?replace=true to a PUT to
+replace an existing value.`,
+			Run: command.Adapt(runHTTP),
+		},
+	}
+}
+
+// dial connects to the service at the address given by the flags, or runs
+// the command given by the flags.
+func dial(env *command.Env) (chirpstore.Store, error) {
+	ctx := env.Context()
+	opts := &chirpstore.StoreOptions{MethodPrefix: flags.Prefix}
+	if flags.Cmd != "" {
+		args := strings.Fields(flags.Cmd)
+		if len(args) == 0 {
+			return chirpstore.Store{}, env.Usagef("no command given by --cmd")
+		}
+		cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+		cmd.Stderr = os.Stderr
+		peer, err := chirpstore.StartCommand(cmd)
+		if err != nil {
+			return chirpstore.Store{}, err
+		}
+		return chirpstore.NewStore(peer, opts), nil
+	}
+	if flags.Addr == "" {
+		return chirpstore.Store{}, errors.New("no service address (set --addr or CHIRPSTORE_ADDR, or --cmd)")
+	}
+	if strings.HasPrefix(flags.Addr, "ws://") || strings.HasPrefix(flags.Addr, "wss://") {
+		config, err := clientTLSConfig()
+		if err != nil {
+			return chirpstore.Store{}, err
+		}
+		peer, err := chirpstore.DialWebSocket(ctx, flags.Addr, &chirpstore.WebSocketOptions{
+			HTTPClient: &http.Client{Transport: &http.Transport{TLSClientConfig: config}},
+		})
+		if err != nil {
+			return chirpstore.Store{}, err
+		}
+		return chirpstore.NewStore(peer, opts), nil
+	}
+	if flags.TLS || flags.TLSCA != "" || flags.Cert != "" {
+		config, err := clientTLSConfig()
+		if err != nil {
+			return chirpstore.Store{}, err
+		}
+		peer, err := chirpstore.DialTLS(ctx, flags.Addr, config)
+		if err != nil {
+			return chirpstore.Store{}, err
+		}
+		return chirpstore.NewStore(peer, opts), nil
+	}
+	ntype, addr := chirp.SplitAddress(flags.Addr)
+	conn, err := new(net.Dialer).DialContext(ctx, ntype, addr)
+	if err != nil {
+		return chirpstore.Store{}, err
+	}
+	peer := chirp.NewPeer().Start(channel.IO(conn, conn))
+	return chirpstore.NewStore(peer, opts), nil
+}
+
+// clientTLSConfig constructs a TLS config from the client flags.
+func clientTLSConfig() (*tls.Config, error) {
+	config := new(tls.Config)
+	if flags.TLSCA != "" {
+		pool, err := loadCertPool(flags.TLSCA)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = pool
+	}
+	if flags.Cert != "" {
+		cert, err := tls.LoadX509KeyPair(flags.Cert, flags.Key)
+		if err != nil {
+			return nil, fmt.Errorf("load certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// withStore calls f with the substore selected by the flags.
+func withStore(env *command.Env, f func(chirpstore.Store) error) error {
+	ctx := env.Context()
+	root, err := dial(env)
+	if err != nil {
+		return err
+	}
+	defer root.Close(ctx)
+
+	var st blob.Store = root
+	for name := range strings.SplitSeq(flags.Sub, "/") {
+		if name == "" {
+			continue
+		}
+		st, err = st.Sub(ctx, name)
+		if err != nil {
+			return fmt.Errorf("open substore %q: %w", name, err)
+		}
+	}
+	return f(st.(chirpstore.Store))
+}
+
+// withKV calls f with the keyspace selected by the flags.
+func withKV(env *command.Env, f func(chirpstore.KV) error) error {
+	return withStore(env, func(st chirpstore.Store) error {
+		kv, err := st.KV(env.Context(), flags.KV)
+		if err != nil {
+			return fmt.Errorf("open keyspace %q: %w", flags.KV, err)
+		}
+		return f(kv.(chirpstore.KV))
+	})
+}
+
+// parseKey decodes a key from the command line according to the flags.
+func parseKey(s string) (string, error) {
+	switch {
+	case flags.Hex:
+		key, err := hex.DecodeString(s)
+		return string(key), err
+	case flags.Base64:
+		key, err := base64.StdEncoding.DecodeString(s)
+		return string(key), err
+	default:
+		return s, nil
+	}
+}
+
+// formatKey encodes a key for output according to the flags.
+func formatKey(key string) string {
+	switch {
+	case flags.Hex:
+		return hex.EncodeToString([]byte(key))
+	case flags.Base64:
+		return base64.StdEncoding.EncodeToString([]byte(key))
+	default:
+		return key
+	}
+}
+
+func parseKeys(args []string) ([]string, error) {
+	keys := make([]string, len(args))
+	for i, arg := range args {
+		key, err := parseKey(arg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %q: %w", arg, err)
+		}
+		keys[i] = key
+	}
+	return keys, nil
+}
+
+func runGet(env *command.Env, arg string) error {
+	key, err := parseKey(arg)
+	if err != nil {
+		return fmt.Errorf("invalid key %q: %w", arg, err)
+	}
+	return withKV(env, func(kv chirpstore.KV) error {
+		data, err := kv.Get(env.Context(), key)
+		if err != nil {
+			return err
+		}
+		_, err = os.Stdout.Write(data)
+		return err
+	})
+}
+
+var putFlags struct {
+	Replace bool `flag:"replace,Replace an existing value for the key"`
+}
+
+func runPut(env *command.Env, arg string, rest ...string) error {
+	key, err := parseKey(arg)
+	if err != nil {
+		return fmt.Errorf("invalid key %q: %w", arg, err)
+	}
+	var data []byte
+	switch {
+	case len(rest) > 1:
+		return env.Usagef("extra arguments after file: %q", rest[1:])
+	case len(rest) == 0 || rest[0] == "-":
+		data, err = io.ReadAll(os.Stdin)
+	default:
+		data, err = os.ReadFile(rest[0])
+	}
+	if err != nil {
+		return fmt.Errorf("read value: %w", err)
+	}
+	return withKV(env, func(kv chirpstore.KV) error {
+		return kv.Put(env.Context(), blob.PutOptions{
+			Key:     key,
+			Data:    data,
+			Replace: putFlags.Replace,
+		})
+	})
+}
+
+func runHas(env *command.Env, args ...string) error {
+	keys, err := parseKeys(args)
+	if err != nil {
+		return err
+	}
+	return withKV(env, func(kv chirpstore.KV) error {
+		present, err := kv.Has(env.Context(), keys...)
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			fmt.Printf("%s\t%v\n", formatKey(key), present.Has(key))
+		}
+		return nil
+	})
+}
+
+func runDelete(env *command.Env, args ...string) error {
+	keys, err := parseKeys(args)
+	if err != nil {
+		return err
+	}
+	return withKV(env, func(kv chirpstore.KV) error {
+		for _, key := range keys {
+			if err := kv.Delete(env.Context(), key); err != nil {
+				return fmt.Errorf("delete %q: %w", formatKey(key), err)
+			}
+		}
+		return nil
+	})
+}
+
+var listFlags struct {
+	Count int `flag:"n,Maximum number of keys to list (0 means no limit)"`
+}
+
+func runList(env *command.Env, args ...string) error {
+	var start string
+	if len(args) > 1 {
+		return env.Usagef("extra arguments after start: %q", args[1:])
+	} else if len(args) == 1 {
+		var err error
+		start, err = parseKey(args[0])
+		if err != nil {
+			return fmt.Errorf("invalid start key: %w", err)
+		}
+	}
+	return withKV(env, func(kv chirpstore.KV) error {
+		var nk int
+		for key, err := range kv.List(env.Context(), start) {
+			if err != nil {
+				return err
+			}
+			fmt.Println(formatKey(key))
+			if nk++; nk == listFlags.Count {
+				break
+			}
+		}
+		return nil
+	})
+}
+
+func runLen(env *command.Env) error {
+	return withKV(env, func(kv chirpstore.KV) error {
+		n, err := kv.Len(env.Context())
+		if err != nil {
+			return err
+		}
+		fmt.Println(n)
+		return nil
+	})
+}
+
+func runStatus(env *command.Env) error {
+	return withKV(env, func(kv chirpstore.KV) error {
+		data, err := kv.Status(env.Context())
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	})
+}
+
+func runNames(env *command.Env) error {
+	return withStore(env, func(st chirpstore.Store) error {
+		kvs, err := st.KVNames(env.Context())
+		if err != nil {
+			return err
+		}
+		subs, err := st.SubNames(env.Context())
+		if err != nil {
+			return err
+		}
+		for _, name := range kvs {
+			fmt.Printf("kv\t%q\n", name)
+		}
+		for _, name := range subs {
+			fmt.Printf("sub\t%q\n", name)
+		}
+		return nil
+	})
+}
+
+var exportFlags struct {
+	Resume bool `flag:"resume,Resume a partial archive in an existing file"`
+	Force  bool `flag:"force,Replace the file if it already exists"`
+}
+
+func runExport(env *command.Env, path string) error {
+	if exportFlags.Resume && exportFlags.Force {
+		return errors.New("--resume and --force cannot be combined")
+	}
+	// Open the keyspace before touching the file, so that a failure to reach
+	// the service does not disturb it.
+	return withKV(env, func(kv chirpstore.KV) error {
+		f, err := openExportFile(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		aw, err := chirpstore.ResumeArchive(f)
+		if err != nil {
+			return fmt.Errorf("open archive: %w", err)
+		}
+		if last, ok := aw.LastKey(); ok {
+			fmt.Fprintf(env, "Resuming after %d records (last key %q)\n", aw.Len(), formatKey(last))
+		}
+		n, err := chirpstore.Export(env.Context(), kv, aw)
+		if err != nil {
+			return fmt.Errorf("export failed after %d records: %w", n, err)
+		}
+		if err := aw.Close(); err != nil {
+			return err
+		}
+		fmt.Fprintf(env, "Exported %d records (%d total)\n", n, aw.Len())
+		return f.Close()
+	})
+}
+
+// openExportFile opens the archive file at path for an export, according to
+// the export flags.
+func openExportFile(path string) (*os.File, error) {
+	switch {
+	case exportFlags.Resume:
+		return os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	case exportFlags.Force:
+		return os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	if errors.Is(err, fs.ErrExist) {
+		return nil, fmt.Errorf("%s already exists (use --resume to continue it, or --force to replace it)", path)
+	}
+	return f, err
+}
+
+var importFlags struct {
+	Replace   bool `flag:"replace,Replace existing values in the keyspace"`
+	VerifyCAS bool `flag:"verify-cas,Verify that keys are content addresses"`
+}
+
+func runImport(env *command.Env, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	ar, err := chirpstore.NewArchiveReader(f)
+	if err != nil {
+		return err
+	}
+	return withKV(env, func(kv chirpstore.KV) error {
+		stats, err := chirpstore.Import(env.Context(), kv, ar, &chirpstore.ImportOptions{
+			Replace:   importFlags.Replace,
+			VerifyCAS: importFlags.VerifyCAS,
+		})
+		fmt.Fprintf(env, "Imported %d records: %d written, %d skipped, %d failed\n",
+			ar.Len(), stats.Written, stats.Skipped, stats.Failed)
+		return err
+	})
+}
+
+func runHTTP(env *command.Env, addr string) error {
+	return withStore(env, func(st chirpstore.Store) error {
+		srv := &http.Server{Addr: addr, Handler: chirpstore.NewHTTPHandler(st, nil)}
+		stop := context.AfterFunc(env.Context(), func() { srv.Shutdown(context.Background()) })
+		defer stop()
+
+		fmt.Fprintf(env, "Serving HTTP gateway at %q\n", addr)
+		if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
+		return nil
+	})
+}
@@ -0,0 +1,258 @@
+package chirpstore
+
+import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+
+	"github.com/creachadair/chirp"
+	"github.com/creachadair/ffs/blob"
+)
+
+// CompressionOptions are settings for compressing values. A nil
+// *CompressionOptions is ready for use and provides default values.
+type CompressionOptions struct {
+	// Values shorter than this many bytes are not compressed.
+	// If zero, a default threshold is used.
+	MinSize int
+
+	// The DEFLATE compression level, as defined by [compress/flate].
+	// If zero, flate.DefaultCompression is used.
+	Level int
+
+	// The maximum size in bytes of a value received compressed by a client,
+	// once decompressed. A value that would exceed this is reported as an
+	// error by Get. If zero, a default of 64 MiB is used. A service ignores
+	// this setting; see [ServiceOptions] MaxValueSize.
+	MaxValueSize int64
+}
+
+// defaultMaxValueSize is the default limit on the decompressed size of a value
+// received compressed.
+const defaultMaxValueSize = 64 << 20
+
+func (o *CompressionOptions) minSize() int {
+	if o == nil || o.MinSize <= 0 {
+		return 512
+	}
+	return o.MinSize
+}
+
+func (o *CompressionOptions) maxValueSize() int64 {
+	if o == nil || o.MaxValueSize <= 0 {
+		return defaultMaxValueSize
+	}
+	return o.MaxValueSize
+}
+
+func (o *CompressionOptions) level() int {
+	if o == nil || o.Level == 0 {
+		return flate.DefaultCompression
+	}
+	return o.Level
+}
+
+// compress reports the compressed form of data, or false if data is too short
+// to compress or compression does not make it smaller.
+func (o *CompressionOptions) compress(data []byte) ([]byte, bool) {
+	if len(data) < o.minSize() {
+		return nil, false
+	}
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, o.level())
+	if err != nil {
+		return nil, false // invalid level; send the value as-is
+	}
+	w.Write(data)
+	if err := w.Close(); err != nil || buf.Len() >= len(data) {
+		return nil, false
+	}
+	return buf.Bytes(), true
+}
+
+// decodeValue returns the plain form of data encoded with codec. If limit > 0,
+// a compressed value that decompresses to more than limit bytes is an error.
+func decodeValue(codec Codec, data []byte, limit int64) ([]byte, error) {
+	switch codec {
+	case CodecNone:
+		return data, nil
+	case CodecDeflate:
+		r := flate.NewReader(bytes.NewReader(data))
+		if limit > 0 {
+			r = io.NopCloser(io.LimitReader(r, limit+1))
+		}
+		out, err := io.ReadAll(r)
+		if err != nil {
+			return nil, fmt.Errorf("decompress value: %w", err)
+		} else if limit > 0 && int64(len(out)) > limit {
+			return nil, fmt.Errorf("decompress value: longer than %d bytes", limit)
+		}
+		return out, nil
+	default:
+		return nil, fmt.Errorf("unknown codec %v", codec)
+	}
+}
+
+// wireCodec is the client state for compressing values sent to and from a
+// service. Whether the service supports compression is negotiated on first use
+// and shared by all the keyspaces of a store.
+type wireCodec struct {
+	opts *CompressionOptions
+	feature
+}
+
+func newWireCodec(opts *CompressionOptions) *wireCodec {
+	if opts == nil {
+		return nil
+	}
+	return &wireCodec{opts: opts}
+}
+
+// maxValueSize reports the limit on the decompressed size of a value received
+// from the service. The limit applies even if compression is not enabled,
+// since the service should not send compressed values in that case.
+func (w *wireCodec) maxValueSize() int64 {
+	if w == nil {
+		return defaultMaxValueSize
+	}
+	return w.opts.maxValueSize()
+}
+
+// ready reports whether compression is enabled for s, negotiating with the
+// service if that has not yet been done.
+func (w *wireCodec) ready(ctx context.Context, s KV) bool {
+	return w != nil && w.check(ctx, s, mCodecs, byte(CodecDeflate))
+}
+
+// feature records whether a service supports an optional feature. Support is
+// negotiated by calling a method that reports the supported variants of the
+// feature, one per byte.
+type feature struct {
+	μ       sync.Mutex
+	settled bool // whether negotiation has completed
+	enabled bool // whether the service supports the feature
+}
+
+// check reports whether the service for s reports supporting variant want by
+// method m, calling m if that has not yet been done. If the service does not
+// have method m, the feature is unsupported. If the call fails for any other
+// reason, check reports false but will try again on the next call.
+func (f *feature) check(ctx context.Context, s KV, m string, want byte) bool {
+	f.μ.Lock()
+	defer f.μ.Unlock()
+	if !f.settled {
+		rsp, err := s.call(ctx, m, nil, true)
+		if err == nil {
+			f.settled, f.enabled = true, slices.Contains(rsp.Data, want)
+		} else if isUnknownMethod(err) {
+			f.settled = true // an older service without the method
+		}
+	}
+	return f.enabled
+}
+
+// isUnknownMethod reports whether err is from a call to a method the service
+// does not have.
+func isUnknownMethod(err error) bool {
+	ce, ok := errors.AsType[*chirp.CallError](err)
+	return ok && ce.Err == nil && ce.Response != nil && ce.Response.Code == chirp.CodeUnknownMethod
+}
+
+// Codecs reports the codecs the service accepts in put requests. The response
+// is a list of [Codec] values, one per byte. A service that reports any codec
+// other than CodecNone also supports the ZGet method.
+func (s *Service) Codecs(ctx context.Context, req *chirp.Request) ([]byte, error) {
+	if len(req.Data) != 0 {
+		return nil, errors.New("no parameters accepted")
+	}
+	return []byte{byte(CodecNone), byte(CodecDeflate)}, nil
+}
+
+// ZGet handles the corresponding method of [blob.KV], for a client that
+// accepts compressed values. The request has the same format as for Get, and
+// the response is a [CodedValue]. The value is compressed if it is stored
+// compressed, or if the service has compression enabled and compressing it
+// makes it smaller (see [ServiceOptions]).
+func (s *Service) ZGet(ctx context.Context, req *chirp.Request) ([]byte, error) {
+	var greq GetRequest
+	if err := greq.Decode(req.Data); err != nil {
+		return nil, err
+	}
+	kv := s.idToKV(greq.ID)
+	if kv == nil {
+		return invalidKeyspaceID(greq.ID)
+	}
+	codec, data, err := s.loadValue(ctx, kv, string(greq.Key))
+	if err != nil {
+		return nil, filterErr(err)
+	}
+	codec, data = s.compressValue(codec, data)
+	return CodedValue{Codec: codec, Data: data}.Encode(), nil
+}
+
+// compressValue returns the form of a value loaded with codec to send to a
+// client that accepts compressed values.
+func (s *Service) compressValue(codec Codec, data []byte) (Codec, []byte) {
+	if codec == CodecNone && s.zopts != nil {
+		if z, ok := s.zopts.compress(data); ok {
+			return CodecDeflate, z
+		}
+	}
+	return codec, data
+}
+
+// loadValue fetches the value of key from kv, along with its codec.
+func (s *Service) loadValue(ctx context.Context, kv blob.KV, key string) (Codec, []byte, error) {
+	data, err := kv.Get(ctx, key)
+	if err != nil || !s.zstore {
+		return CodecNone, data, err
+	}
+	var v CodedValue
+	if err := v.Decode(data); err != nil {
+		return 0, nil, fmt.Errorf("stored value of %q: %w", key, err)
+	}
+	return v.Codec, v.Data, nil
+}
+
+// getValue fetches the plain value of key from kv.
+func (s *Service) getValue(ctx context.Context, kv blob.KV, key string) ([]byte, error) {
+	codec, data, err := s.loadValue(ctx, kv, key)
+	if err != nil {
+		return nil, err
+	}
+	return decodeValue(codec, data, 0) // the service wrote it; see storeValue
+}
+
+// storeValue writes the value in opts, encoded with codec, to kv. If the
+// service stores values compressed, the value is stored with its codec, and
+// compressed if possible; otherwise it is stored plain.
+func (s *Service) storeValue(ctx context.Context, kv blob.KV, opts blob.PutOptions, codec Codec) error {
+	if !s.zstore {
+		data, err := decodeValue(codec, opts.Data, s.maxValue)
+		if err != nil {
+			return err
+		}
+		opts.Data = data
+		return kv.Put(ctx, opts)
+	}
+	switch codec {
+	case CodecNone:
+		if z, ok := s.zopts.compress(opts.Data); ok {
+			codec, opts.Data = CodecDeflate, z
+		}
+	case CodecDeflate:
+		// Check that the value can be read back before storing it.
+		if _, err := decodeValue(codec, opts.Data, s.maxValue); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown codec %v", codec)
+	}
+	opts.Data = CodedValue{Codec: codec, Data: opts.Data}.Encode()
+	return kv.Put(ctx, opts)
+}
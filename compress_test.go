@@ -0,0 +1,190 @@
+package chirpstore_test
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/creachadair/chirp"
+	"github.com/creachadair/chirpstore"
+	"github.com/creachadair/ffs/blob"
+	"github.com/creachadair/ffs/blob/memstore"
+	"github.com/creachadair/ffs/blob/storetest"
+)
+
+// compressService returns a peer for a service with the given options over a
+// new memory store, the store, and a counter of the packet bytes exchanged.
+func compressService(t *testing.T, opts *chirpstore.ServiceOptions) (*chirp.Peer, blob.Store, *atomic.Int64) {
+	t.Helper()
+	mem := memstore.New(nil)
+	peer := newTestService(t, chirpstore.NewService(mem, opts))
+
+	nb := new(atomic.Int64)
+	peer.LogPackets(func(pkt chirp.Packet, _ chirp.PacketDir) {
+		nb.Add(int64(len(pkt.Payload)))
+	})
+	return peer, mem, nb
+}
+
+func TestCompression(t *testing.T) {
+	ctx := t.Context()
+	text := strings.Repeat("all work and no play makes jack a dull boy\n", 100)
+	zopts := &chirpstore.CompressionOptions{MinSize: 64}
+
+	t.Run("Store", func(t *testing.T) {
+		peer, _, _ := compressService(t, &chirpstore.ServiceOptions{
+			Compression:     zopts,
+			StoreCompressed: true,
+		})
+		storetest.Run(t, chirpstore.NewStore(peer, &chirpstore.StoreOptions{Compression: zopts}))
+	})
+
+	t.Run("Wire", func(t *testing.T) {
+		peer, mem, nb := compressService(t, &chirpstore.ServiceOptions{Compression: zopts})
+		kv := mustKV(t, chirpstore.NewStore(peer, &chirpstore.StoreOptions{Compression: zopts}), "text")
+
+		nb.Store(0)
+		if err := kv.Put(ctx, blob.PutOptions{Key: "k", Data: []byte(text)}); err != nil {
+			t.Fatalf("Put: unexpected error: %v", err)
+		}
+		if got, err := kv.Get(ctx, "k"); err != nil || string(got) != text {
+			t.Errorf("Get: got (%d bytes, %v), want %d bytes", len(got), err, len(text))
+		}
+		if n := nb.Load(); n >= int64(len(text)) {
+			t.Errorf("Put and Get sent %d bytes, want < %d", n, len(text))
+		}
+
+		// The service stores the value plain.
+		if got, err := mustKVAny(t, mem, "text").Get(ctx, "k"); err != nil || string(got) != text {
+			t.Errorf("Stored value: got (%d bytes, %v), want plain text", len(got), err)
+		}
+	})
+
+	t.Run("StoreCompressed", func(t *testing.T) {
+		peer, mem, _ := compressService(t, &chirpstore.ServiceOptions{StoreCompressed: true})
+		zkv := mustKV(t, chirpstore.NewStore(peer, &chirpstore.StoreOptions{Compression: zopts}), "text")
+		pkv := mustKV(t, chirpstore.NewStore(peer, nil), "text")
+		raw := mustKVAny(t, mem, "text")
+
+		// A value sent compressed and a value sent plain are both stored
+		// compressed, and both clients can read both.
+		if err := zkv.Put(ctx, blob.PutOptions{Key: "z", Data: []byte(text)}); err != nil {
+			t.Fatalf("Put compressed: unexpected error: %v", err)
+		}
+		if err := pkv.Put(ctx, blob.PutOptions{Key: "p", Data: []byte(text)}); err != nil {
+			t.Fatalf("Put plain: unexpected error: %v", err)
+		}
+		for _, key := range []string{"z", "p"} {
+			data, err := raw.Get(ctx, key)
+			if err != nil {
+				t.Fatalf("Get stored %q: unexpected error: %v", key, err)
+			}
+			var v chirpstore.CodedValue
+			if err := v.Decode(data); err != nil {
+				t.Errorf("Decode stored %q: %v", key, err)
+			} else if v.Codec != chirpstore.CodecDeflate || len(v.Data) >= len(text) {
+				t.Errorf("Stored %q: got %v with %d bytes, want deflate < %d", key, v.Codec, len(v.Data), len(text))
+			}
+			for _, kv := range []chirpstore.KV{zkv, pkv} {
+				if got, err := kv.Get(ctx, key); err != nil || string(got) != text {
+					t.Errorf("Get %q: got (%d bytes, %v), want %d bytes", key, len(got), err, len(text))
+				}
+			}
+		}
+
+		// A short value is stored uncompressed.
+		if err := pkv.Put(ctx, blob.PutOptions{Key: "s", Data: []byte("short")}); err != nil {
+			t.Fatalf("Put: unexpected error: %v", err)
+		}
+		if data, err := raw.Get(ctx, "s"); err != nil || string(data) != "\x00short" {
+			t.Errorf("Stored short value: got (%q, %v), want %q", data, err, "\x00short")
+		}
+	})
+
+	t.Run("MaxValueSize", func(t *testing.T) {
+		// A compressed value is rejected if it is too long when decompressed,
+		// whether or not the service stores it compressed.
+		for _, stored := range []bool{false, true} {
+			peer, mem, _ := compressService(t, &chirpstore.ServiceOptions{
+				StoreCompressed: stored,
+				MaxValueSize:    1000,
+			})
+			kv := mustKV(t, chirpstore.NewStore(peer, &chirpstore.StoreOptions{Compression: zopts}), "text")
+			if err := kv.Put(ctx, blob.PutOptions{Key: "big", Data: []byte(text)}); err == nil {
+				t.Errorf("Put %d bytes (stored=%v): got nil, want error", len(text), stored)
+			}
+			if got, err := mustKVAny(t, mem, "text").Get(ctx, "big"); !blob.IsKeyNotFound(err) {
+				t.Errorf("Stored value (stored=%v): got (%d bytes, %v), want key not found", stored, len(got), err)
+			}
+
+			// A plain value of any size is accepted, even if the service
+			// compresses it for storage.
+			pkv := mustKV(t, chirpstore.NewStore(peer, nil), "text")
+			if err := pkv.Put(ctx, blob.PutOptions{Key: "plain", Data: []byte(text)}); err != nil {
+				t.Errorf("Put plain %d bytes (stored=%v): unexpected error: %v", len(text), stored, err)
+			}
+			for _, kv := range []chirpstore.KV{kv, pkv} {
+				if got, err := kv.Get(ctx, "plain"); err != nil || string(got) != text {
+					t.Errorf("Get plain (stored=%v): got (%d bytes, %v), want %d bytes", stored, len(got), err, len(text))
+				}
+			}
+
+			small := text[:1000]
+			if err := kv.Put(ctx, blob.PutOptions{Key: "small", Data: []byte(small)}); err != nil {
+				t.Errorf("Put %d bytes (stored=%v): unexpected error: %v", len(small), stored, err)
+			} else if got, err := kv.Get(ctx, "small"); err != nil || string(got) != small {
+				t.Errorf("Get (stored=%v): got (%d bytes, %v), want %d bytes", stored, len(got), err, len(small))
+			}
+		}
+	})
+
+	t.Run("ClientMaxValueSize", func(t *testing.T) {
+		// A client rejects a compressed value from the service that is too long
+		// when decompressed.
+		peer, _, _ := compressService(t, &chirpstore.ServiceOptions{StoreCompressed: true})
+		pkv := mustKV(t, chirpstore.NewStore(peer, nil), "text")
+		if err := pkv.Put(ctx, blob.PutOptions{Key: "big", Data: []byte(text)}); err != nil {
+			t.Fatalf("Put: unexpected error: %v", err)
+		}
+		for _, sum := range []chirpstore.Checksum{chirpstore.ChecksumNone, chirpstore.ChecksumCRC32C} {
+			zkv := mustKV(t, chirpstore.NewStore(peer, &chirpstore.StoreOptions{
+				Compression: &chirpstore.CompressionOptions{MaxValueSize: 1000},
+				Checksum:    sum,
+			}), "text")
+			if got, err := zkv.Get(ctx, "big"); err == nil {
+				t.Errorf("Get %d bytes (checksum %v): got %d bytes, want error", len(text), sum, len(got))
+			}
+		}
+	})
+
+	t.Run("Unsupported", func(t *testing.T) {
+		// A service without the codecs method does not get compressed values.
+		mem := memstore.New(nil)
+		kv := mustKV(t, chirpstore.NewStore(oldService(t, mem), &chirpstore.StoreOptions{Compression: zopts}), "text")
+		if err := kv.Put(ctx, blob.PutOptions{Key: "k", Data: []byte(text)}); err != nil {
+			t.Fatalf("Put: unexpected error: %v", err)
+		}
+		if got, err := kv.Get(ctx, "k"); err != nil || string(got) != text {
+			t.Errorf("Get: got (%d bytes, %v), want %d bytes", len(got), err, len(text))
+		}
+		if got, err := mustKVAny(t, mem, "text").Get(ctx, "k"); err != nil || string(got) != text {
+			t.Errorf("Stored value: got (%d bytes, %v), want plain text", len(got), err)
+		}
+	})
+}
+
+// oldService returns a peer for a service over st that lacks the methods for
+// compression and checksums, as an older service would.
+func oldService(t *testing.T, st blob.Store) *chirp.Peer {
+	t.Helper()
+	return newTestService(t, chirpstore.NewService(st, &chirpstore.ServiceOptions{
+		Authorize: func(_ context.Context, method string) error {
+			switch method {
+			case "codecs", "zget", "checksums", "cget":
+				return chirp.ErrUnknownMethod
+			}
+			return nil
+		},
+	}))
+}
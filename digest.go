@@ -0,0 +1,253 @@
+package chirpstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"iter"
+	"math/bits"
+
+	"github.com/creachadair/ffs/blob"
+	"github.com/creachadair/taskgroup"
+)
+
+// keyDigest accumulates the digest of a range of keys. The digest is the sum,
+// modulo 2^256, of the SHA-256 hashes of the keys, as big-endian integers.
+// Since the digest does not depend on how the keys are grouped, the digests
+// of adjacent ranges can be merged into the digest of their union.
+type keyDigest struct {
+	start string
+	count int64
+	sum   [4]uint64 // little-endian limbs
+}
+
+func newKeyDigest(start string) *keyDigest { return &keyDigest{start: start} }
+
+func (d *keyDigest) add(key string) {
+	h := sha256.Sum256([]byte(key))
+	var v [4]uint64
+	for i := range v {
+		v[i] = binary.BigEndian.Uint64(h[24-8*i:])
+	}
+	d.addSum(v)
+	d.count++
+}
+
+// merge adds the keys of o, which must be the range following d, to d.
+func (d *keyDigest) merge(o *keyDigest) {
+	d.addSum(o.sum)
+	d.count += o.count
+}
+
+func (d *keyDigest) addSum(v [4]uint64) {
+	var carry uint64
+	for i := range d.sum {
+		d.sum[i], carry = bits.Add64(d.sum[i], v[i], carry)
+	}
+}
+
+func (d *keyDigest) digestRange() DigestRange {
+	sum := make([]byte, 0, 32)
+	for i := len(d.sum) - 1; i >= 0; i-- {
+		sum = binary.BigEndian.AppendUint64(sum, d.sum[i])
+	}
+	return DigestRange{Start: []byte(d.start), Count: d.count, Sum: sum}
+}
+
+// splitDigest accumulates the digests of at most n contiguous ranges of keys
+// having approximately equal numbers of keys, in one pass over the keys. The
+// keys are collected into chunks of equal size; when there are 2n full
+// chunks, adjacent pairs are merged and the chunk size doubles.
+type splitDigest struct {
+	n      int
+	size   int64 // the number of keys per chunk
+	chunks []*keyDigest
+}
+
+func newSplitDigest(start string, n int) *splitDigest {
+	return &splitDigest{n: n, size: 1, chunks: []*keyDigest{newKeyDigest(start)}}
+}
+
+func (s *splitDigest) add(key string) {
+	last := s.chunks[len(s.chunks)-1]
+	if last.count == s.size {
+		if len(s.chunks) == 2*s.n {
+			s.halve()
+			s.size *= 2
+		}
+		last = newKeyDigest(key)
+		s.chunks = append(s.chunks, last)
+	}
+	last.add(key)
+}
+
+// halve merges adjacent pairs of chunks.
+func (s *splitDigest) halve() {
+	out := s.chunks[:0]
+	for i := 0; i < len(s.chunks); i += 2 {
+		c := s.chunks[i]
+		if i+1 < len(s.chunks) {
+			c.merge(s.chunks[i+1])
+		}
+		out = append(out, c)
+	}
+	s.chunks = out
+}
+
+func (s *splitDigest) digestRanges() []DigestRange {
+	if len(s.chunks) > s.n {
+		s.halve()
+	}
+	out := make([]DigestRange, len(s.chunks))
+	for i, c := range s.chunks {
+		out[i] = c.digestRange()
+	}
+	return out
+}
+
+// listRange returns an iterator over the keys of kv from start (inclusive) to
+// end (exclusive). If end == "" the range has no upper bound.
+func listRange(ctx context.Context, kv blob.KVCore, start, end string) iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		for key, err := range kv.List(ctx, start) {
+			if err == nil && end != "" && key >= end {
+				return
+			} else if !yield(key, err) || err != nil {
+				return
+			}
+		}
+	}
+}
+
+const (
+	diffFanout   = 16 // number of subranges per level of comparison
+	diffLeafSize = 64 // ranges this size or smaller are compared by listing
+)
+
+// Diff reports the keys present in a but not in b (onlyA), and the keys present
+// in b but not in a (onlyB), in lexicographic order. Values are not compared.
+//
+// Diff compares digests of key ranges on each side (see [KV.Digest]) and
+// recursively subdivides only the ranges whose digests differ. The number of
+// round trips is thus logarithmic in the size of the keyspaces, and only the
+// keys of small ranges that differ are sent to the caller. Each service still
+// lists the keys of every range it digests, so comparing keyspaces that differ
+// scans each of them once for each level of subdivision.
+func Diff(ctx context.Context, a, b KV) (onlyA, onlyB []string, _ error) {
+	d, err := diffKV(ctx, a, b)
+	if err != nil {
+		return nil, nil, err
+	}
+	return d.onlyA, d.onlyB, nil
+}
+
+type differ struct {
+	ctx          context.Context
+	a, b         KV
+	onlyA, onlyB []string
+	countA       int64 // total keys in a
+}
+
+func diffKV(ctx context.Context, a, b KV) (*differ, error) {
+	ra, err := a.Digest(ctx, "", "", 1)
+	if err != nil {
+		return nil, err
+	}
+	rb, err := b.Digest(ctx, "", "", 1)
+	if err != nil {
+		return nil, err
+	}
+	d := &differ{ctx: ctx, a: a, b: b, countA: ra[0].Count}
+	if err := d.diff("", "", ra[0], rb[0]); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// diff records the differing keys in the range from start to end, given the
+// digests ra and rb of that range in a and b respectively.
+func (d *differ) diff(start, end string, ra, rb DigestRange) error {
+	if ra.Count == rb.Count && bytes.Equal(ra.Sum, rb.Sum) {
+		return nil // no differences in this range
+	} else if ra.Count <= diffLeafSize && rb.Count <= diffLeafSize {
+		return d.diffLeaf(start, end)
+	}
+
+	// Partition the range using the side with more keys, then fetch digests of
+	// the same subranges from the other side.
+	big, small, swap := d.a, d.b, false
+	if rb.Count > ra.Count {
+		big, small, swap = d.b, d.a, true
+	}
+	parts, err := big.Digest(d.ctx, start, end, diffFanout)
+	if err != nil {
+		return err
+	} else if len(parts) == 1 {
+		return d.diffLeaf(start, end) // the range cannot be subdivided
+	}
+	partEnd := func(i int) string {
+		if i+1 < len(parts) {
+			return string(parts[i+1].Start)
+		}
+		return end
+	}
+	other := make([]DigestRange, len(parts))
+	g := taskgroup.New(nil)
+	for i, p := range parts {
+		g.Go(func() error {
+			rs, err := small.Digest(d.ctx, string(p.Start), partEnd(i), 1)
+			if err == nil {
+				other[i] = rs[0]
+			}
+			return err
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return err
+	}
+
+	for i, p := range parts {
+		pa, pb := p, other[i]
+		if swap {
+			pa, pb = pb, pa
+		}
+		if err := d.diff(string(p.Start), partEnd(i), pa, pb); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// diffLeaf records the differing keys in the range from start to end by
+// listing the keys of both sides.
+func (d *differ) diffLeaf(start, end string) error {
+	var keysA, keysB []string
+	for key, err := range listRange(d.ctx, d.a, start, end) {
+		if err != nil {
+			return err
+		}
+		keysA = append(keysA, key)
+	}
+	for key, err := range listRange(d.ctx, d.b, start, end) {
+		if err != nil {
+			return err
+		}
+		keysB = append(keysB, key)
+	}
+	for len(keysA) != 0 && len(keysB) != 0 {
+		switch {
+		case keysA[0] < keysB[0]:
+			d.onlyA = append(d.onlyA, keysA[0])
+			keysA = keysA[1:]
+		case keysA[0] > keysB[0]:
+			d.onlyB = append(d.onlyB, keysB[0])
+			keysB = keysB[1:]
+		default:
+			keysA, keysB = keysA[1:], keysB[1:]
+		}
+	}
+	d.onlyA = append(d.onlyA, keysA...)
+	d.onlyB = append(d.onlyB, keysB...)
+	return nil
+}
@@ -0,0 +1,111 @@
+package chirpstore_test
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/creachadair/chirpstore"
+	"github.com/creachadair/ffs/blob"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDigest(t *testing.T) {
+	ctx := t.Context()
+	kv, want := newTestKV(t, 100)
+
+	for _, split := range []int{0, 1, 7, 16, 1000} {
+		rs, err := kv.Digest(ctx, "", "", split)
+		if err != nil {
+			t.Fatalf("Digest split=%d: unexpected error: %v", split, err)
+		}
+		if len(rs) > max(split, 1) {
+			t.Errorf("Digest split=%d: got %d ranges, want at most %d", split, len(rs), split)
+		}
+		var total int64
+		for _, r := range rs {
+			total += r.Count
+		}
+		if total != int64(len(want)) {
+			t.Errorf("Digest split=%d: got %d keys, want %d", split, total, len(want))
+		}
+
+		// Each subrange has the same digest when requested on its own.
+		for i, r := range rs {
+			end := ""
+			if i+1 < len(rs) {
+				end = string(rs[i+1].Start)
+			}
+			got, err := kv.Digest(ctx, string(r.Start), end, 1)
+			if err != nil {
+				t.Fatalf("Digest %q..%q: unexpected error: %v", r.Start, end, err)
+			} else if diff := cmp.Diff(got, []chirpstore.DigestRange{r}); diff != "" {
+				t.Errorf("Digest %q..%q (-got, +want):\n%s", r.Start, end, diff)
+			}
+		}
+	}
+
+	// A bounded range should count only the keys it contains.
+	rs, err := kv.Digest(ctx, "key-0010", "key-0020", 1)
+	if err != nil {
+		t.Fatalf("Digest: unexpected error: %v", err)
+	} else if len(rs) != 1 || rs[0].Count != 10 {
+		t.Errorf("Digest: got %+v, want 1 range with 10 keys", rs)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	ctx := t.Context()
+	a := mustKV(t, chirpstore.NewStore(newTestService(t, nil), nil), "a")
+	b := mustKV(t, chirpstore.NewStore(newTestService(t, nil), nil), "b")
+
+	put := func(kv blob.KV, key string) {
+		t.Helper()
+		if err := kv.Put(ctx, blob.PutOptions{Key: key, Data: []byte(key)}); err != nil {
+			t.Fatalf("Put %q: unexpected error: %v", key, err)
+		}
+	}
+	var wantA, wantB []string
+	for i := range 2000 {
+		key := fmt.Sprintf("k%05d", i)
+		switch {
+		case i%397 == 5:
+			put(a, key)
+			wantA = append(wantA, key)
+		case i%541 == 7:
+			put(b, key)
+			wantB = append(wantB, key)
+		default:
+			put(a, key)
+			put(b, key)
+		}
+	}
+	for i := range 150 { // a dense run of extra keys in b
+		key := fmt.Sprintf("k01000-%03d", i)
+		put(b, key)
+		wantB = append(wantB, key)
+	}
+	slices.Sort(wantB)
+
+	t.Run("Identical", func(t *testing.T) {
+		onlyA, onlyB, err := chirpstore.Diff(ctx, a, a)
+		if err != nil {
+			t.Fatalf("Diff: unexpected error: %v", err)
+		} else if len(onlyA) != 0 || len(onlyB) != 0 {
+			t.Errorf("Diff: got %q, %q, want no differences", onlyA, onlyB)
+		}
+	})
+
+	t.Run("Different", func(t *testing.T) {
+		onlyA, onlyB, err := chirpstore.Diff(ctx, a, b)
+		if err != nil {
+			t.Fatalf("Diff: unexpected error: %v", err)
+		}
+		if diff := cmp.Diff(onlyA, wantA); diff != "" {
+			t.Errorf("Keys only in a (-got, +want):\n%s", diff)
+		}
+		if diff := cmp.Diff(onlyB, wantB); diff != "" {
+			t.Errorf("Keys only in b (-got, +want):\n%s", diff)
+		}
+	})
+}
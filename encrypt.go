@@ -0,0 +1,256 @@
+package chirpstore
+
+import (
+	"context"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"iter"
+
+	"github.com/creachadair/ffs/blob"
+)
+
+// minBlindKeyLen is the minimum length in bytes of a key blinding secret.
+const minBlindKeyLen = 16
+
+// EncryptedStore implements the [blob.StoreCloser] interface by encrypting
+// the keys and values of an underlying store, typically a [Store] connected to
+// a service that is not trusted with the contents.
+//
+// Each value is sealed with an AEAD cipher under a random nonce, bound to the
+// key and keyspace it is stored under, so the service cannot read values or
+// move them between keys or keyspaces without detection.
+//
+// Each key is blinded deterministically: the stored key is the HMAC-SHA256 of
+// the keyspace path and the key, followed by the key sealed with a nonce
+// derived from that HMAC, so the same key is always stored the same way in a
+// given keyspace and can be recovered by List without fetching its value. The
+// service learns only the length of each key. The names of keyspaces and
+// substores are not blinded. The keyspace path is the sequence of substore
+// and keyspace names leading to it from the store passed to
+// [NewEncryptedStore], so a keyspace must be opened by the same path each
+// time.
+//
+// Because keys are stored in blinded form, List reports keys in the order of
+// their blinded forms, which is unrelated to their lexicographic order; see
+// [EncryptedKV.List].
+type EncryptedStore struct {
+	st   blob.Store
+	aead cipher.AEAD
+	mac  []byte
+	path string // encoded substore path, see appendPath
+}
+
+// NewEncryptedStore constructs an [EncryptedStore] that encrypts the contents
+// of st. Values and keys are sealed with aead, and keys are blinded with
+// HMAC-SHA256 using blindKey, which must be at least 16 bytes long. The same
+// cipher and blinding key must be used each time the store is opened.
+func NewEncryptedStore(st blob.Store, aead cipher.AEAD, blindKey []byte) (EncryptedStore, error) {
+	if aead == nil {
+		return EncryptedStore{}, errors.New("no cipher provided")
+	} else if len(blindKey) < minBlindKeyLen {
+		return EncryptedStore{}, fmt.Errorf("blinding key is %d bytes, want at least %d", len(blindKey), minBlindKeyLen)
+	}
+	return EncryptedStore{st: st, aead: aead, mac: blindKey}, nil
+}
+
+// KV implements a method of [blob.Store]. A successful result has concrete
+// type [EncryptedKV].
+func (s EncryptedStore) KV(ctx context.Context, name string) (blob.KV, error) {
+	kv, err := s.st.KV(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return EncryptedKV{kv: kv, aead: s.aead, mac: s.mac, path: appendPath(s.path, 'k', name)}, nil
+}
+
+// CAS implements a method of [blob.Store]. Content addresses are computed from
+// the plaintext of each value, and blinded like other keys.
+func (s EncryptedStore) CAS(ctx context.Context, name string) (blob.CAS, error) {
+	return blob.CASFromKVError(s.KV(ctx, name))
+}
+
+// Sub implements a method of [blob.Store]. A successful result has concrete
+// type [EncryptedStore], using the same keys as s.
+func (s EncryptedStore) Sub(ctx context.Context, name string) (blob.Store, error) {
+	sub, err := s.st.Sub(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	s.st = sub
+	s.path = appendPath(s.path, 's', name)
+	return s, nil
+}
+
+// Close implements part of the [blob.StoreCloser] interface. It closes the
+// underlying store, if it implements [blob.StoreCloser].
+func (s EncryptedStore) Close(ctx context.Context) error {
+	if sc, ok := s.st.(blob.StoreCloser); ok {
+		return sc.Close(ctx)
+	}
+	return nil
+}
+
+// EncryptedKV implements the [blob.KV] interface for a keyspace of an
+// [EncryptedStore].
+type EncryptedKV struct {
+	kv   blob.KV
+	aead cipher.AEAD
+	mac  []byte
+	path string // encoded keyspace path, see appendPath
+}
+
+// Get implements a method of [blob.KV].
+func (s EncryptedKV) Get(ctx context.Context, key string) ([]byte, error) {
+	bkey := s.blindKey(key)
+	data, err := s.kv.Get(ctx, bkey)
+	if err != nil {
+		return nil, unblindErr(err, key)
+	}
+	return s.open(bkey, data)
+}
+
+// Has implements a method of [blob.KV].
+func (s EncryptedKV) Has(ctx context.Context, keys ...string) (blob.KeySet, error) {
+	bkeys := make([]string, len(keys))
+	for i, key := range keys {
+		bkeys[i] = s.blindKey(key)
+	}
+	found, err := s.kv.Has(ctx, bkeys...)
+	if err != nil {
+		return nil, err
+	}
+	var out blob.KeySet
+	for i, bkey := range bkeys {
+		if found.Has(bkey) {
+			out.Add(keys[i])
+		}
+	}
+	return out, nil
+}
+
+// Put implements a method of [blob.KV].
+func (s EncryptedKV) Put(ctx context.Context, opts blob.PutOptions) error {
+	bkey := s.blindKey(opts.Key)
+	sealed, err := s.seal(bkey, opts.Data)
+	if err != nil {
+		return err
+	}
+	err = s.kv.Put(ctx, blob.PutOptions{Key: bkey, Data: sealed, Replace: opts.Replace})
+	return unblindErr(err, opts.Key)
+}
+
+// Delete implements a method of [blob.KV].
+func (s EncryptedKV) Delete(ctx context.Context, key string) error {
+	return unblindErr(s.kv.Delete(ctx, s.blindKey(key)), key)
+}
+
+// List implements a method of [blob.KV]. The keys are reported in the order of
+// their blinded forms, not in lexicographic order. If start == "", all the
+// keys are listed; otherwise List reports the keys whose blinded forms are
+// greater than or equal to that of start. Thus a listing can be resumed from
+// any key previously reported by List, but start cannot be used to select a
+// lexicographic range of keys.
+func (s EncryptedKV) List(ctx context.Context, start string) iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		bstart := ""
+		if start != "" {
+			bstart = s.blindKey(start)
+		}
+		for bkey, err := range s.kv.List(ctx, bstart) {
+			if err != nil {
+				yield("", err)
+				return
+			}
+			key, err := s.unblindKey(bkey)
+			if !yield(key, err) || err != nil {
+				return
+			}
+		}
+	}
+}
+
+// Len implements a method of [blob.KV].
+func (s EncryptedKV) Len(ctx context.Context) (int64, error) { return s.kv.Len(ctx) }
+
+// blindKey returns the stored form of key: a prefix of the HMAC of the
+// keyspace path and key, followed by key sealed with a nonce taken from that
+// prefix.
+func (s EncryptedKV) blindKey(key string) string {
+	tag := s.tag(key)
+	return string(s.aead.Seal(tag, tag[:s.aead.NonceSize()], []byte(key), []byte(s.path)))
+}
+
+// unblindKey recovers a key from its stored form.
+func (s EncryptedKV) unblindKey(bkey string) (string, error) {
+	n := s.tagLen()
+	if len(bkey) < n {
+		return "", fmt.Errorf("invalid encrypted key %q", bkey)
+	}
+	tag := []byte(bkey[:n])
+	key, err := s.aead.Open(nil, tag[:s.aead.NonceSize()], []byte(bkey[n:]), []byte(s.path))
+	if err != nil || !hmac.Equal(tag, s.tag(string(key))) {
+		return "", fmt.Errorf("invalid encrypted key %q", bkey)
+	}
+	return string(key), nil
+}
+
+// tag returns the blinding prefix for key.
+func (s EncryptedKV) tag(key string) []byte {
+	h := hmac.New(sha256.New, s.mac)
+	h.Write([]byte(s.path))
+	h.Write([]byte(key))
+	n := s.tagLen()
+	return h.Sum(nil)[:n:n]
+}
+
+// tagLen returns the length of the blinding prefix, which must be long enough
+// to hold a nonce.
+func (s EncryptedKV) tagLen() int { return max(16, s.aead.NonceSize()) }
+
+// seal encrypts data for storage under bkey, as nonce || ciphertext.
+func (s EncryptedKV) seal(bkey string, data []byte) ([]byte, error) {
+	nonce := make([]byte, s.aead.NonceSize(), s.aead.NonceSize()+len(data)+s.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return s.aead.Seal(nonce, nonce, data, s.valueAD(bkey)), nil
+}
+
+// open decrypts a value stored under bkey.
+func (s EncryptedKV) open(bkey string, sealed []byte) ([]byte, error) {
+	n := s.aead.NonceSize()
+	if len(sealed) < n {
+		return nil, errors.New("invalid encrypted value: too short")
+	}
+	data, err := s.aead.Open(nil, sealed[:n], sealed[n:], s.valueAD(bkey))
+	if err != nil {
+		return nil, fmt.Errorf("invalid encrypted value: %w", err)
+	}
+	return data, nil
+}
+
+// valueAD returns the additional data that binds a value to the keyspace path
+// and the blinded key it is stored under.
+func (s EncryptedKV) valueAD(bkey string) []byte { return append([]byte(s.path), bkey...) }
+
+// appendPath returns path extended by a substore ('s') or keyspace ('k') name.
+// Each name is prefixed by its kind and length, so distinct paths never share
+// an encoding and a keyspace path is never a prefix of another.
+func appendPath(path string, kind byte, name string) string {
+	buf := binary.AppendUvarint(append([]byte(path), kind), uint64(len(name)))
+	return string(append(buf, name...))
+}
+
+// unblindErr replaces the blinded key in a key error with the original key,
+// so the caller sees the key it asked for.
+func unblindErr(err error, key string) error {
+	if ke, ok := errors.AsType[*blob.KeyError](err); ok {
+		return &blob.KeyError{Key: key, Err: ke.Err}
+	}
+	return err
+}
@@ -0,0 +1,221 @@
+package chirpstore_test
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/creachadair/chirpstore"
+	"github.com/creachadair/ffs/blob"
+	"github.com/google/go-cmp/cmp"
+)
+
+func newGCM(t *testing.T, key string) cipher.AEAD {
+	t.Helper()
+	block, err := aes.NewCipher([]byte(key))
+	if err != nil {
+		t.Fatalf("NewCipher: %v", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatalf("NewGCM: %v", err)
+	}
+	return aead
+}
+
+func TestEncrypted(t *testing.T) {
+	ctx := t.Context()
+	const blindKey = "0123456789abcdef"
+	aead := newGCM(t, "fedcba9876543210")
+
+	rs := chirpstore.NewStore(newTestService(t, nil), nil)
+	if _, err := chirpstore.NewEncryptedStore(rs, aead, []byte("short")); err == nil {
+		t.Error("NewEncryptedStore: short blinding key was accepted")
+	}
+	es, err := chirpstore.NewEncryptedStore(rs, aead, []byte(blindKey))
+	if err != nil {
+		t.Fatalf("NewEncryptedStore: unexpected error: %v", err)
+	}
+	kv := mustKVAny(t, es, "secret")
+	raw := mustKV(t, rs, "secret")
+
+	keys := []string{"apple", "banana", "cherry", "date", "elderberry"}
+	for _, key := range keys {
+		if err := kv.Put(ctx, blob.PutOptions{Key: key, Data: []byte("value of " + key)}); err != nil {
+			t.Fatalf("Put %q: unexpected error: %v", key, err)
+		}
+	}
+
+	t.Run("RoundTrip", func(t *testing.T) {
+		for _, key := range keys {
+			if got, err := kv.Get(ctx, key); err != nil || string(got) != "value of "+key {
+				t.Errorf("Get %q: got (%q, %v), want %q", key, got, err, "value of "+key)
+			}
+		}
+		if ks, err := kv.Has(ctx, "apple", "fig", "date"); err != nil {
+			t.Errorf("Has: unexpected error: %v", err)
+		} else if got := ks.Slice(); !slices.Equal(sorted(got), []string{"apple", "date"}) {
+			t.Errorf("Has: got %q, want [apple date]", got)
+		}
+		if n, err := kv.Len(ctx); err != nil || n != int64(len(keys)) {
+			t.Errorf("Len: got (%d, %v), want %d", n, err, len(keys))
+		}
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		_, err := kv.Get(ctx, "fig")
+		if ke, ok := errors.AsType[*blob.KeyError](err); !ok || !blob.IsKeyNotFound(err) || ke.Key != "fig" {
+			t.Errorf("Get missing: got %v, want key not found for fig", err)
+		}
+		if err := kv.Put(ctx, blob.PutOptions{Key: "apple", Data: []byte("x")}); !blob.IsKeyExists(err) {
+			t.Errorf("Put existing: got %v, want key exists", err)
+		}
+		if err := kv.Delete(ctx, "fig"); !blob.IsKeyNotFound(err) {
+			t.Errorf("Delete missing: got %v, want key not found", err)
+		}
+	})
+
+	t.Run("Opaque", func(t *testing.T) {
+		// The service sees neither the keys nor the values.
+		for _, bkey := range listKeys(t, raw) {
+			data, err := raw.Get(ctx, bkey)
+			if err != nil {
+				t.Fatalf("Get raw: unexpected error: %v", err)
+			}
+			for _, key := range keys {
+				if strings.Contains(bkey, key) || strings.Contains(string(data), key) {
+					t.Errorf("Stored key %q or value %q contains %q", bkey, data, key)
+				}
+			}
+		}
+	})
+
+	t.Run("List", func(t *testing.T) {
+		got := listKeys(t, kv)
+		if diff := cmp.Diff(sorted(got), keys); diff != "" {
+			t.Errorf("List (-got, +want):\n%s", diff)
+		}
+
+		// A listing can be resumed from any key it reported.
+		if diff := cmp.Diff(listKeysFrom(t, kv, got[2]), got[2:]); diff != "" {
+			t.Errorf("List from %q (-got, +want):\n%s", got[2], diff)
+		}
+	})
+
+	t.Run("Keyspace", func(t *testing.T) {
+		// Moving a sealed value or key to a different keyspace is detected.
+		moved := mustKVAny(t, es, "moved")
+		rawMoved := mustKV(t, rs, "moved")
+		if err := moved.Put(ctx, blob.PutOptions{Key: "apple", Data: []byte("other apple")}); err != nil {
+			t.Fatalf("Put: unexpected error: %v", err)
+		}
+		mkey := listKeys(t, rawMoved)[0]
+
+		// List reports keys in the order of their blinded forms, so the raw key
+		// for "apple" is at the same position.
+		bkey := listKeys(t, raw)[slices.Index(listKeys(t, kv), "apple")]
+		data, err := raw.Get(ctx, bkey)
+		if err != nil {
+			t.Fatalf("Get raw: unexpected error: %v", err)
+		}
+		if mkey == bkey {
+			t.Errorf("Key %q has the same blinded form in both keyspaces", "apple")
+		}
+
+		if err := rawMoved.Put(ctx, blob.PutOptions{Key: mkey, Data: data, Replace: true}); err != nil {
+			t.Fatalf("Put raw: unexpected error: %v", err)
+		}
+		if got, err := moved.Get(ctx, "apple"); err == nil {
+			t.Errorf("Get moved value: got %q, want error", got)
+		}
+
+		if err := rawMoved.Delete(ctx, mkey); err != nil {
+			t.Fatalf("Delete raw: unexpected error: %v", err)
+		}
+		if err := rawMoved.Put(ctx, blob.PutOptions{Key: bkey, Data: data}); err != nil {
+			t.Fatalf("Put raw: unexpected error: %v", err)
+		}
+		var lerr error
+		for _, err := range moved.List(ctx, "") {
+			lerr = err
+		}
+		if lerr == nil {
+			t.Error("List moved key: got no error, want error")
+		}
+	})
+
+	t.Run("Tamper", func(t *testing.T) {
+		// Moving a sealed value to a different key is detected.
+		bkeys := listKeys(t, raw)
+		data, err := raw.Get(ctx, bkeys[0])
+		if err != nil {
+			t.Fatalf("Get raw: unexpected error: %v", err)
+		}
+		if err := raw.Put(ctx, blob.PutOptions{Key: bkeys[1], Data: data, Replace: true}); err != nil {
+			t.Fatalf("Put raw: unexpected error: %v", err)
+		}
+		victim := listKeys(t, kv)[1]
+		if got, err := kv.Get(ctx, victim); err == nil {
+			t.Errorf("Get %q: got %q, want error", victim, got)
+		}
+		if err := kv.Delete(ctx, victim); err != nil {
+			t.Errorf("Delete %q: unexpected error: %v", victim, err)
+		}
+	})
+
+	t.Run("CAS", func(t *testing.T) {
+		cas, err := es.CAS(ctx, "cas")
+		if err != nil {
+			t.Fatalf("CAS: unexpected error: %v", err)
+		}
+		key, err := cas.CASPut(ctx, []byte("hello, world"))
+		if err != nil {
+			t.Fatalf("CASPut: unexpected error: %v", err)
+		} else if want := cas.CASKey(ctx, []byte("hello, world")); key != want {
+			t.Errorf("CASPut: got key %x, want %x", key, want)
+		}
+		if got, err := cas.Get(ctx, key); err != nil || string(got) != "hello, world" {
+			t.Errorf("Get %x: got (%q, %v), want hello, world", key, got, err)
+		}
+		if got := listKeys(t, cas); !slices.Equal(got, []string{key}) {
+			t.Errorf("List: got %x, want [%x]", got, key)
+		}
+	})
+
+	t.Run("WrongKey", func(t *testing.T) {
+		other, err := chirpstore.NewEncryptedStore(rs, aead, []byte("another blinding key"))
+		if err != nil {
+			t.Fatalf("NewEncryptedStore: unexpected error: %v", err)
+		}
+		okv := mustKVAny(t, other, "secret")
+		if got, err := okv.Get(ctx, "apple"); !blob.IsKeyNotFound(err) {
+			t.Errorf("Get with wrong key: got (%q, %v), want key not found", got, err)
+		}
+	})
+}
+
+func mustKVAny(t *testing.T, st blob.Store, name string) blob.KV {
+	t.Helper()
+	kv, err := st.KV(t.Context(), name)
+	if err != nil {
+		t.Fatalf("KV %q: unexpected error: %v", name, err)
+	}
+	return kv
+}
+
+func listKeysFrom(t *testing.T, kv blob.KVCore, start string) []string {
+	t.Helper()
+	var out []string
+	for key, err := range kv.List(t.Context(), start) {
+		if err != nil {
+			t.Fatalf("List: unexpected error: %v", err)
+		}
+		out = append(out, key)
+	}
+	return out
+}
+
+func sorted(ss []string) []string { return slices.Sorted(slices.Values(ss)) }
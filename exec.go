@@ -0,0 +1,63 @@
+package chirpstore
+
+import (
+	"errors"
+	"net"
+	"os"
+	"os/exec"
+
+	"github.com/creachadair/chirp"
+	"github.com/creachadair/chirp/channel"
+)
+
+// StartCommand starts cmd and returns a peer that communicates with it over
+// the standard input and output of cmd. The command must serve a peer on its
+// stdin and stdout, as "chirpstore serve --stdio" does; for example:
+//
+//	cmd := exec.Command("ssh", "host", "chirpstore", "serve", "--stdio")
+//	cmd.Stderr = os.Stderr
+//	peer, err := chirpstore.StartCommand(cmd)
+//	...
+//	st := chirpstore.NewStore(peer, nil)
+//
+// The caller must not set cmd.Stdin or cmd.Stdout. When the peer is stopped,
+// the command's stdin is closed and the peer waits for the command to exit.
+func StartCommand(cmd *exec.Cmd) (*chirp.Peer, error) {
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		in.Close()
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return chirp.NewPeer().Start(cmdChannel{IOChannel: channel.IO(out, in), cmd: cmd}), nil
+}
+
+// cmdChannel is a [chirp.Channel] on the pipes of a subprocess.
+type cmdChannel struct {
+	channel.IOChannel
+	cmd *exec.Cmd
+}
+
+// Recv implements a method of the [chirp.Channel] interface.
+func (c cmdChannel) Recv() (chirp.Packet, error) {
+	pkt, err := c.IOChannel.Recv()
+	if errors.Is(err, os.ErrClosed) {
+		// Waiting for the command closes its stdout, which may race with a
+		// pending receive after the channel is closed. Report that as a closed
+		// channel rather than a protocol error.
+		err = net.ErrClosed
+	}
+	return pkt, err
+}
+
+// Close closes the command's stdin, which signals the command to exit, and
+// waits for it to do so.
+func (c cmdChannel) Close() error {
+	return errors.Join(c.IOChannel.Close(), c.cmd.Wait())
+}
@@ -3,14 +3,19 @@ module github.com/creachadair/chirpstore
 go 1.26
 
 require (
+	github.com/coder/websocket v1.8.15
 	github.com/creachadair/chirp v0.4.12
+	github.com/creachadair/command v0.2.11
 	github.com/creachadair/ffs v0.18.2
+	github.com/creachadair/flax v0.0.6
+	github.com/creachadair/mds v0.30.5
+	github.com/creachadair/taskgroup v0.14.4
 	github.com/google/go-cmp v0.7.0
 )
 
 require (
-	github.com/creachadair/mds v0.30.5 // indirect
-	github.com/creachadair/taskgroup v0.14.4 // indirect
+	github.com/creachadair/atomicfile v0.4.2 // indirect
+	github.com/creachadair/msync v0.10.0 // indirect
 	golang.org/x/crypto v0.54.0 // indirect
 	golang.org/x/sys v0.47.0 // indirect
 )
@@ -0,0 +1,264 @@
+package chirpstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/creachadair/ffs/blob"
+)
+
+// HTTPOptions are optional settings for [NewHTTPHandler].
+// A nil *HTTPOptions is ready for use and provides default values.
+type HTTPOptions struct {
+	// The maximum size in bytes of a value accepted by PUT.
+	// If zero, a default limit is used.
+	MaxValueSize int64
+
+	// The maximum number of keys reported by a single listing request.
+	// If zero, a default limit is used.
+	MaxListKeys int
+}
+
+func (o *HTTPOptions) maxValueSize() int64 {
+	if o == nil || o.MaxValueSize <= 0 {
+		return 64 << 20
+	}
+	return o.MaxValueSize
+}
+
+func (o *HTTPOptions) maxListKeys() int {
+	if o == nil || o.MaxListKeys <= 0 {
+		return 1000
+	}
+	return o.MaxListKeys
+}
+
+// NewHTTPHandler returns an [http.Handler] that serves REST requests on the
+// keyspaces of st. Although st may be any [blob.Store], it is typically a
+// [Store] connected to a remote service.
+//
+// The path of each request has the form /{sub...}/{keyspace}/{key}, where
+// the sub names, if any, select a substore of st, and the keyspace names a
+// keyspace within that substore. Each path segment is percent-decoded, so
+// names and keys containing "/" or arbitrary bytes must be escaped as for
+// [url.PathEscape]. The methods are:
+//
+//	GET    /.../{keyspace}/{key}  -- fetch the value of key
+//	HEAD   /.../{keyspace}/{key}  -- check whether key is present
+//	PUT    /.../{keyspace}/{key}  -- store the request body as the value of key
+//	DELETE /.../{keyspace}/{key}  -- delete key
+//	GET    /.../{keyspace}/       -- list keys (see below)
+//
+// By default PUT does not replace an existing value; to replace it, add the
+// query parameter "replace=true".
+//
+// A request for a key that does not exist reports 404 Not Found, and a PUT
+// without replacement for a key that exists reports 409 Conflict. Successful
+// PUT and DELETE requests report 204 No Content.
+//
+// A listing reports a JSON object with the keys of the keyspace in order:
+//
+//	{"keys": ["key1", "key2", ...], "next": "key3"}
+//
+// The keys are escaped as for [url.PathEscape], so that each can be used in the
+// path of a request for that key. The "start" query parameter gives the first
+// key to list, as an ordinary query value, and "limit" gives the maximum
+// number of keys. If there are more keys to list, "next" is the start key for
+// the following page, escaped as for [url.QueryEscape], so that it can be used
+// as is in "start=" for the next request; otherwise it is omitted.
+func NewHTTPHandler(st blob.Store, opts *HTTPOptions) http.Handler {
+	return httpHandler{st: st, maxValue: opts.maxValueSize(), maxList: opts.maxListKeys()}
+}
+
+type httpHandler struct {
+	st       blob.Store
+	maxValue int64
+	maxList  int
+}
+
+// ServeHTTP implements the [http.Handler] interface.
+func (h httpHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	subs, kvName, key, err := parseHTTPPath(r.URL.EscapedPath())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if key == "" {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+	} else {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete:
+		default:
+			w.Header().Set("Allow", "GET, HEAD, PUT, DELETE")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+	}
+
+	ctx := r.Context()
+	kv, err := h.openKV(ctx, subs, kvName)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+
+	switch {
+	case key == "":
+		h.list(w, r, kv)
+
+	case r.Method == http.MethodGet:
+		data, err := kv.Get(ctx, key)
+		if err != nil {
+			httpError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.Write(data)
+
+	case r.Method == http.MethodHead:
+		ks, err := kv.Has(ctx, key)
+		if err != nil {
+			httpError(w, err)
+		} else if !ks.Has(key) {
+			w.WriteHeader(http.StatusNotFound)
+		}
+
+	case r.Method == http.MethodPut:
+		replace, err := parseBoolParam(r, "replace")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, h.maxValue))
+		if err != nil {
+			if _, ok := errors.AsType[*http.MaxBytesError](err); ok {
+				http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			} else {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			}
+			return
+		}
+		if err := kv.Put(ctx, blob.PutOptions{Key: key, Data: data, Replace: replace}); err != nil {
+			httpError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	case r.Method == http.MethodDelete:
+		if err := kv.Delete(ctx, key); err != nil {
+			httpError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// httpListing is the JSON format of a listing response.
+type httpListing struct {
+	Keys []string `json:"keys"`
+	Next string   `json:"next,omitempty"`
+}
+
+func (h httpHandler) list(w http.ResponseWriter, r *http.Request, kv blob.KV) {
+	q := r.URL.Query()
+	start := q.Get("start")
+	limit := h.maxList
+	if s := q.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			http.Error(w, fmt.Sprintf("invalid limit %q", s), http.StatusBadRequest)
+			return
+		}
+		limit = min(n, h.maxList)
+	}
+
+	out := httpListing{Keys: []string{}}
+	for key, err := range kv.List(r.Context(), start) {
+		if err != nil {
+			httpError(w, err)
+			return
+		} else if len(out.Keys) == limit {
+			out.Next = url.QueryEscape(key)
+			break
+		}
+		out.Keys = append(out.Keys, url.PathEscape(key))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+// openKV opens the named keyspace in the substore of h.st given by subs.
+func (h httpHandler) openKV(ctx context.Context, subs []string, name string) (blob.KV, error) {
+	st := h.st
+	for _, sub := range subs {
+		var err error
+		st, err = st.Sub(ctx, sub)
+		if err != nil {
+			return nil, fmt.Errorf("open substore %q: %w", sub, err)
+		}
+	}
+	kv, err := st.KV(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("open keyspace %q: %w", name, err)
+	}
+	return kv, nil
+}
+
+// parseHTTPPath splits an escaped request path into substore names, keyspace
+// name, and key, and decodes each. The key is empty for a listing request.
+func parseHTTPPath(path string) (subs []string, kv, key string, _ error) {
+	segs := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	if len(segs) < 2 {
+		return nil, "", "", errors.New("path must have the form /{sub...}/{keyspace}/{key}")
+	}
+	for i, seg := range segs {
+		dec, err := url.PathUnescape(seg)
+		if err != nil {
+			return nil, "", "", fmt.Errorf("invalid path segment %q: %w", seg, err)
+		}
+		segs[i] = dec
+	}
+	n := len(segs)
+	return segs[:n-2], segs[n-2], segs[n-1], nil
+}
+
+// parseBoolParam reports the value of the named boolean query parameter,
+// which is false if it is not set.
+func parseBoolParam(r *http.Request, name string) (bool, error) {
+	s := r.URL.Query().Get(name)
+	if s == "" {
+		return false, nil
+	}
+	v, err := strconv.ParseBool(s)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s parameter %q", name, s)
+	}
+	return v, nil
+}
+
+// httpError writes an error response for err, with a status code matching
+// the error where possible.
+func httpError(w http.ResponseWriter, err error) {
+	code := http.StatusInternalServerError
+	switch {
+	case blob.IsKeyNotFound(err):
+		code = http.StatusNotFound
+	case blob.IsKeyExists(err):
+		code = http.StatusConflict
+	case errors.Is(err, ErrUnavailable):
+		code = http.StatusServiceUnavailable
+	}
+	http.Error(w, err.Error(), code)
+}
@@ -0,0 +1,152 @@
+package chirpstore_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/creachadair/chirpstore"
+	"github.com/creachadair/ffs/blob"
+)
+
+func TestHTTP(t *testing.T) {
+	rs := chirpstore.NewStore(newTestService(t, nil), nil)
+	srv := httptest.NewServer(chirpstore.NewHTTPHandler(rs, &chirpstore.HTTPOptions{MaxListKeys: 2}))
+	defer srv.Close()
+
+	call := func(method, path, body string) (int, string) {
+		t.Helper()
+		req, err := http.NewRequestWithContext(t.Context(), method, srv.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("NewRequest: %v", err)
+		}
+		rsp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+		defer rsp.Body.Close()
+		data, err := io.ReadAll(rsp.Body)
+		if err != nil {
+			t.Fatalf("%s %s: read body: %v", method, path, err)
+		}
+		return rsp.StatusCode, string(data)
+	}
+	check := func(method, path, body string, wantCode int, wantBody string) {
+		t.Helper()
+		code, got := call(method, path, body)
+		if code != wantCode {
+			t.Errorf("%s %s: got code %d, want %d (%s)", method, path, code, wantCode, got)
+		} else if wantBody != "" && got != wantBody {
+			t.Errorf("%s %s: got body %q, want %q", method, path, got, wantBody)
+		}
+	}
+	list := func(path string) (keys []string, next string) {
+		t.Helper()
+		code, body := call("GET", path, "")
+		if code != http.StatusOK {
+			t.Fatalf("GET %s: got code %d (%s)", path, code, body)
+		}
+		var out struct {
+			Keys []string `json:"keys"`
+			Next string   `json:"next"`
+		}
+		if err := json.Unmarshal([]byte(body), &out); err != nil {
+			t.Fatalf("GET %s: decode: %v", path, err)
+		}
+		return out.Keys, out.Next
+	}
+
+	t.Run("Keys", func(t *testing.T) {
+		check("GET", "/kv/alpha", "", http.StatusNotFound, "")
+		check("HEAD", "/kv/alpha", "", http.StatusNotFound, "")
+		check("PUT", "/kv/alpha", "one", http.StatusNoContent, "")
+		check("PUT", "/kv/alpha", "two", http.StatusConflict, "")
+		check("GET", "/kv/alpha", "", http.StatusOK, "one")
+		check("PUT", "/kv/alpha?replace=true", "two", http.StatusNoContent, "")
+		check("GET", "/kv/alpha", "", http.StatusOK, "two")
+		check("HEAD", "/kv/alpha", "", http.StatusOK, "")
+		check("DELETE", "/kv/alpha", "", http.StatusNoContent, "")
+		check("DELETE", "/kv/alpha", "", http.StatusNotFound, "")
+	})
+	t.Run("Substores", func(t *testing.T) {
+		check("PUT", "/a/b/kv/k%2Fey", "nested", http.StatusNoContent, "")
+		check("GET", "/a/b/kv/k%2Fey", "", http.StatusOK, "nested")
+		check("GET", "/a/kv/k%2Fey", "", http.StatusNotFound, "")
+
+		v, err := mustKV(t, subStore(t, rs, "a", "b"), "kv").Get(t.Context(), "k/ey")
+		if err != nil || string(v) != "nested" {
+			t.Errorf("Get via store: got (%q, %v), want nested", v, err)
+		}
+	})
+	t.Run("List", func(t *testing.T) {
+		for _, key := range []string{"p", "q/r", "s"} {
+			check("PUT", "/list/"+strings.ReplaceAll(key, "/", "%2F"), key, http.StatusNoContent, "")
+		}
+		keys, next := list("/list/")
+		if diff := cmp.Diff(keys, []string{"p", "q%2Fr"}); diff != "" || next != "s" {
+			t.Errorf("List page 1: next=%q, keys (-got, +want):\n%s", next, diff)
+		}
+		keys, next = list("/list/?start=" + next)
+		if diff := cmp.Diff(keys, []string{"s"}); diff != "" || next != "" {
+			t.Errorf("List page 2: next=%q, keys (-got, +want):\n%s", next, diff)
+		}
+		keys, _ = list("/list/?limit=1&start=q%2F")
+		if diff := cmp.Diff(keys, []string{"q%2Fr"}); diff != "" {
+			t.Errorf("List with start (-got, +want):\n%s", diff)
+		}
+	})
+	t.Run("ListResume", func(t *testing.T) {
+		// Each next value can be passed back as start, whatever the key.
+		want := []string{"50%", "a b", "a+b", "a/b", "a?b&c=d"}
+		for _, key := range want {
+			check("PUT", "/resume/"+url.PathEscape(key), key, http.StatusNoContent, "")
+		}
+		var got []string
+		for next := "?limit=1"; ; {
+			if len(got) > len(want) {
+				t.Fatalf("Listing did not end after %d keys: %q", len(got), got)
+			}
+			keys, n := list("/resume/" + next)
+			for _, key := range keys {
+				k, err := url.PathUnescape(key)
+				if err != nil {
+					t.Fatalf("Unescape %q: %v", key, err)
+				}
+				got = append(got, k)
+			}
+			if n == "" {
+				break
+			}
+			next = "?limit=1&start=" + n
+		}
+		if diff := cmp.Diff(got, want); diff != "" {
+			t.Errorf("Listed keys (-got, +want):\n%s", diff)
+		}
+	})
+	t.Run("Errors", func(t *testing.T) {
+		check("GET", "/nokey", "", http.StatusBadRequest, "")
+		check("PUT", "/kv/", "", http.StatusMethodNotAllowed, "")
+		check("POST", "/kv/alpha", "", http.StatusMethodNotAllowed, "")
+		check("PUT", "/kv/alpha?replace=maybe", "", http.StatusBadRequest, "")
+		check("GET", "/kv/?limit=0", "", http.StatusBadRequest, "")
+	})
+}
+
+// subStore opens the substore of st along the given path of names.
+func subStore(t *testing.T, st blob.Store, names ...string) blob.Store {
+	t.Helper()
+	for _, name := range names {
+		sub, err := st.Sub(t.Context(), name)
+		if err != nil {
+			t.Fatalf("Sub %q: unexpected error: %v", name, err)
+		}
+		st = sub
+	}
+	return st
+}
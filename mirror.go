@@ -0,0 +1,216 @@
+package chirpstore
+
+import (
+	"context"
+	"iter"
+	"sync/atomic"
+
+	"github.com/creachadair/ffs/blob"
+	"github.com/creachadair/taskgroup"
+)
+
+// MirrorOptions are optional settings for [Mirror].
+// A nil *MirrorOptions is ready for use and provides default values.
+type MirrorOptions struct {
+	// If true, report the changes that would be made without modifying the
+	// target keyspace.
+	DryRun bool
+
+	// If true, delete keys from the target that are not in the source.
+	DeleteExtraneous bool
+
+	// If true, and both the source and target are [KV] values, locate the
+	// keys that differ by comparing digests (see [Diff]) instead of listing
+	// all the keys of the source.
+	UseDigest bool
+
+	// The maximum number of concurrent copies between keyspaces.
+	// If zero, a default limit is used.
+	Concurrency int
+
+	// The number of keys to list and check for presence in each batch.
+	// If zero, a default size is used.
+	BatchSize int
+}
+
+func (o *MirrorOptions) dryRun() bool           { return o != nil && o.DryRun }
+func (o *MirrorOptions) deleteExtraneous() bool { return o != nil && o.DeleteExtraneous }
+func (o *MirrorOptions) useDigest() bool        { return o != nil && o.UseDigest }
+
+func (o *MirrorOptions) concurrency() int {
+	if o == nil || o.Concurrency <= 0 {
+		return 16
+	}
+	return o.Concurrency
+}
+
+func (o *MirrorOptions) batchSize() int {
+	if o == nil || o.BatchSize <= 0 {
+		return 256
+	}
+	return o.BatchSize
+}
+
+// MirrorStats report the results of a call to [Mirror].
+type MirrorStats struct {
+	Checked int64 // keys in the source checked against the target
+	Copied  int64 // keys copied from the source to the target
+	Deleted int64 // extraneous keys deleted from the target
+}
+
+// Mirror copies keys from src to dst that are present in src but missing from
+// dst, and reports what changed. The keys of src are listed in batches, and
+// each batch is checked against dst with a single call to Has. If dry run is
+// enabled, the reported counts are the keys that would have been copied and
+// deleted. Values of keys already present in dst are not compared or updated.
+//
+// Typically src and dst are [KV] values connected to different services.
+func Mirror(ctx context.Context, src blob.KVCore, dst blob.KV, opts *MirrorOptions) (MirrorStats, error) {
+	m := &mirror{ctx: ctx, src: src, dst: dst, dryRun: opts.dryRun()}
+	if opts.useDigest() {
+		sk, ok1 := src.(KV)
+		dk, ok2 := dst.(KV)
+		if ok1 && ok2 {
+			err := m.runDigest(sk, dk, opts)
+			return m.stats(), err
+		}
+	}
+	err := m.runList(opts)
+	return m.stats(), err
+}
+
+type mirror struct {
+	ctx    context.Context
+	src    blob.KVCore
+	dst    blob.KV
+	dryRun bool
+
+	nChecked, nCopied, nDeleted atomic.Int64
+}
+
+func (m *mirror) stats() MirrorStats {
+	return MirrorStats{Checked: m.nChecked.Load(), Copied: m.nCopied.Load(), Deleted: m.nDeleted.Load()}
+}
+
+// runList mirrors by listing the keys of both keyspaces.
+func (m *mirror) runList(opts *MirrorOptions) error {
+	g, start := taskgroup.New(nil).Limit(opts.concurrency())
+	for batch, err := range listBatches(m.ctx, m.src, opts.batchSize()) {
+		if err != nil {
+			g.Wait()
+			return err
+		}
+		m.nChecked.Add(int64(len(batch)))
+		missing, err := blob.SyncKeys(m.ctx, m.dst, batch)
+		if err != nil {
+			g.Wait()
+			return err
+		}
+		for key := range missing {
+			start(func() error { return m.copyKey(key) })
+		}
+	}
+	if err := g.Wait(); err != nil || !opts.deleteExtraneous() {
+		return err
+	}
+
+	for batch, err := range listBatches(m.ctx, m.dst, opts.batchSize()) {
+		if err != nil {
+			return err
+		}
+		extra, err := blob.SyncKeys(m.ctx, m.src, batch)
+		if err != nil {
+			return err
+		}
+
+		// Finish each batch before listing the next, since dst is not required
+		// to permit modification while listing.
+		g, start := taskgroup.New(nil).Limit(opts.concurrency())
+		for key := range extra {
+			start(func() error { return m.deleteKey(key) })
+		}
+		if err := g.Wait(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runDigest mirrors by comparing digests of the keyspaces.
+func (m *mirror) runDigest(src, dst KV, opts *MirrorOptions) error {
+	d, err := diffKV(m.ctx, src, dst)
+	if err != nil {
+		return err
+	}
+	m.nChecked.Store(d.countA)
+
+	g, start := taskgroup.New(nil).Limit(opts.concurrency())
+	for _, key := range d.onlyA {
+		start(func() error { return m.copyKey(key) })
+	}
+	if opts.deleteExtraneous() {
+		for _, key := range d.onlyB {
+			start(func() error { return m.deleteKey(key) })
+		}
+	}
+	return g.Wait()
+}
+
+func (m *mirror) copyKey(key string) error {
+	if m.dryRun {
+		m.nCopied.Add(1)
+		return nil
+	}
+	data, err := m.src.Get(m.ctx, key)
+	if blob.IsKeyNotFound(err) {
+		return nil // deleted since it was listed
+	} else if err != nil {
+		return err
+	}
+	err = m.dst.Put(m.ctx, blob.PutOptions{Key: key, Data: data})
+	if blob.IsKeyExists(err) {
+		return nil // added since it was checked
+	} else if err == nil {
+		m.nCopied.Add(1)
+	}
+	return err
+}
+
+func (m *mirror) deleteKey(key string) error {
+	if m.dryRun {
+		m.nDeleted.Add(1)
+		return nil
+	}
+	err := m.dst.Delete(m.ctx, key)
+	if blob.IsKeyNotFound(err) {
+		return nil // deleted since it was listed
+	} else if err == nil {
+		m.nDeleted.Add(1)
+	}
+	return err
+}
+
+// listBatches returns an iterator over batches of up to size keys from kv, in
+// lexicographic order. The listing is restarted for each batch, so the caller
+// may safely modify kv between batches.
+func listBatches(ctx context.Context, kv blob.KVCore, size int) iter.Seq2[[]string, error] {
+	return func(yield func([]string, error) bool) {
+		var start string
+		for {
+			var batch []string
+			for key, err := range kv.List(ctx, start) {
+				if err != nil {
+					yield(nil, err)
+					return
+				} else if len(batch) == size {
+					break
+				}
+				batch = append(batch, key)
+			}
+			if len(batch) == 0 || !yield(batch, nil) {
+				return
+			}
+			start = batch[len(batch)-1] + "\x00" // the next possible key
+		}
+	}
+}
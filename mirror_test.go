@@ -0,0 +1,72 @@
+package chirpstore_test
+
+import (
+	"testing"
+
+	"github.com/creachadair/chirpstore"
+	"github.com/creachadair/ffs/blob"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestMirror(t *testing.T) {
+	t.Run("List", func(t *testing.T) { testMirror(t, false) })
+	t.Run("Digest", func(t *testing.T) { testMirror(t, true) })
+}
+
+func testMirror(t *testing.T, useDigest bool) {
+	ctx := t.Context()
+	src := mustKV(t, chirpstore.NewStore(newTestService(t, nil), nil), "primary")
+	dst := mustKV(t, chirpstore.NewStore(newTestService(t, nil), nil), "standby")
+
+	put := func(kv blob.KV, keys ...string) {
+		t.Helper()
+		for _, key := range keys {
+			if err := kv.Put(ctx, blob.PutOptions{Key: key, Data: []byte("v:" + key)}); err != nil {
+				t.Fatalf("Put %q: unexpected error: %v", key, err)
+			}
+		}
+	}
+	put(src, "a", "b", "c", "d", "e", "f", "g")
+	put(dst, "b", "d", "x", "y")
+
+	check := func(opts *chirpstore.MirrorOptions, want chirpstore.MirrorStats, wantKeys ...string) {
+		t.Helper()
+		if opts == nil {
+			opts = new(chirpstore.MirrorOptions)
+		}
+		opts.UseDigest = useDigest
+		got, err := chirpstore.Mirror(ctx, src, dst, opts)
+		if err != nil {
+			t.Fatalf("Mirror: unexpected error: %v", err)
+		}
+		if diff := cmp.Diff(got, want); diff != "" {
+			t.Errorf("Mirror stats (-got, +want):\n%s", diff)
+		}
+		if diff := cmp.Diff(listKeys(t, dst), wantKeys); diff != "" {
+			t.Errorf("Target keys (-got, +want):\n%s", diff)
+		}
+	}
+
+	t.Run("DryRun", func(t *testing.T) {
+		check(&chirpstore.MirrorOptions{DryRun: true, DeleteExtraneous: true, BatchSize: 2},
+			chirpstore.MirrorStats{Checked: 7, Copied: 5, Deleted: 2},
+			"b", "d", "x", "y")
+	})
+	t.Run("Copy", func(t *testing.T) {
+		check(&chirpstore.MirrorOptions{BatchSize: 3},
+			chirpstore.MirrorStats{Checked: 7, Copied: 5},
+			"a", "b", "c", "d", "e", "f", "g", "x", "y")
+	})
+	t.Run("Delete", func(t *testing.T) {
+		check(&chirpstore.MirrorOptions{DeleteExtraneous: true, BatchSize: 2},
+			chirpstore.MirrorStats{Checked: 7, Deleted: 2},
+			"a", "b", "c", "d", "e", "f", "g")
+	})
+	t.Run("Idempotent", func(t *testing.T) {
+		check(nil, chirpstore.MirrorStats{Checked: 7}, "a", "b", "c", "d", "e", "f", "g")
+	})
+
+	if got, err := dst.Get(ctx, "e"); err != nil || string(got) != "v:e" {
+		t.Errorf("Get e: got (%q, %v), want v:e", got, err)
+	}
+}
@@ -0,0 +1,453 @@
+package chirpstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"iter"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/creachadair/ffs/blob"
+)
+
+// ErrNoQuorum is reported by writes to a [ReplicatedKV] that are not accepted
+// by enough replicas to succeed.
+var ErrNoQuorum = errors.New("write quorum not reached")
+
+// ReplicaOptions are optional settings for a [ReplicatedKV].
+// A nil *ReplicaOptions is ready for use and provides default values.
+type ReplicaOptions struct {
+	// The number of replicas that must accept a write of a new key for it to
+	// succeed. If zero, a majority of the replicas is required. Deletes and
+	// writes that replace a value must be accepted by every replica.
+	WriteQuorum int
+
+	// How long a replica is skipped for reads after a call to it fails,
+	// unless no other replica is available. If zero, a default is used.
+	FailureTimeout time.Duration
+
+	// If positive, a read that has not completed after this delay is also
+	// sent to the next replica ("hedged"), and the first successful answer is
+	// used; the calls still pending are cancelled. If zero, a read is sent to
+	// the next replica only after the previous one fails.
+	HedgeDelay time.Duration
+}
+
+func (o *ReplicaOptions) writeQuorum(n int) int {
+	if o == nil || o.WriteQuorum <= 0 {
+		return n/2 + 1
+	}
+	return o.WriteQuorum
+}
+
+func (o *ReplicaOptions) failureTimeout() time.Duration {
+	if o == nil || o.FailureTimeout <= 0 {
+		return 5 * time.Second
+	}
+	return o.FailureTimeout
+}
+
+func (o *ReplicaOptions) hedgeDelay() time.Duration {
+	if o == nil || o.HedgeDelay <= 0 {
+		return 0
+	}
+	return o.HedgeDelay
+}
+
+// ReplicatedKV implements the [blob.KV] interface by storing each value in
+// several replica keyspaces, typically [KV] values connected to different
+// services.
+//
+// Writes are sent to all the replicas. A write of a new key succeeds if at
+// least the write quorum of replicas accept it, but a delete or a write that
+// replaces a value succeeds only if every replica accepts it. Reads are sent
+// to the replica that has responded fastest, skipping replicas that have
+// recently failed, and fall back to the others if the key is not found. When
+// a read finds a key that is missing from a replica it tried first, it copies
+// the value to that replica ("read repair"). Since a key can be missing from
+// a replica only because a write of it did not reach that replica, and not
+// because of a delete that succeeded, read repair does not restore deleted or
+// replaced values. Reads may optionally be hedged; see
+// [ReplicaOptions.HedgeDelay].
+type ReplicatedKV struct {
+	reps     []*replica
+	quorum   int
+	cooldown time.Duration
+	hedge    time.Duration
+	repaired atomic.Int64
+}
+
+// NewReplicatedKV constructs a [ReplicatedKV] over the given replicas.
+func NewReplicatedKV(replicas []blob.KV, opts *ReplicaOptions) (*ReplicatedKV, error) {
+	if len(replicas) == 0 {
+		return nil, errors.New("no replicas provided")
+	}
+	q := opts.writeQuorum(len(replicas))
+	if q > len(replicas) {
+		return nil, fmt.Errorf("write quorum %d exceeds %d replicas", q, len(replicas))
+	}
+	s := &ReplicatedKV{quorum: q, cooldown: opts.failureTimeout(), hedge: opts.hedgeDelay()}
+	for _, kv := range replicas {
+		s.reps = append(s.reps, &replica{kv: kv})
+	}
+	return s, nil
+}
+
+// ReplicaStats record statistics about a [ReplicatedKV].
+type ReplicaStats struct {
+	Repaired int64 // missing copies restored by read repair
+}
+
+// Stats reports statistics about s.
+func (s *ReplicatedKV) Stats() ReplicaStats {
+	return ReplicaStats{Repaired: s.repaired.Load()}
+}
+
+// Get implements a method of [blob.KV]. If key is not found in any replica,
+// Get reports [blob.ErrKeyNotFound]; if it is not found in some replicas and
+// others fail, Get reports their errors.
+func (s *ReplicatedKV) Get(ctx context.Context, key string) ([]byte, error) {
+	order := s.order()
+	data, win, errs := hedge(ctx, s.hedge, order, func(ctx context.Context, r *replica) ([]byte, error) {
+		return r.get(ctx, key, s.cooldown)
+	})
+	var missing []*replica
+	var failed []error
+	for i, err := range errs {
+		if blob.IsKeyNotFound(err) {
+			missing = append(missing, order[i])
+		} else if err != nil {
+			failed = append(failed, err)
+		}
+	}
+	if win >= 0 {
+		s.repair(ctx, key, data, missing)
+		return data, nil
+	} else if err := ctx.Err(); err != nil {
+		return nil, err
+	} else if len(failed) == 0 {
+		return nil, blob.KeyNotFound(key)
+	}
+	return nil, errors.Join(failed...)
+}
+
+// Has implements a method of [blob.KV].
+func (s *ReplicatedKV) Has(ctx context.Context, keys ...string) (blob.KeySet, error) {
+	// Ask the first replica (possibly hedged) about all the keys, then ask the
+	// rest in turn about any keys not yet found.
+	order := s.order()
+	first, win, herrs := hedge(ctx, s.hedge, order, func(ctx context.Context, r *replica) (blob.KeySet, error) {
+		return r.has(ctx, keys, s.cooldown)
+	})
+	var errs []error
+	var rest []*replica
+	for i, r := range order {
+		if herrs[i] != nil {
+			errs = append(errs, herrs[i])
+		} else if i != win {
+			rest = append(rest, r)
+		}
+	}
+	if win < 0 {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return nil, errors.Join(errs...)
+	}
+
+	var found blob.KeySet
+	missing := make(map[string][]*replica) // key to replicas lacking it
+	want := keys
+	for i, r := range append([]*replica{order[win]}, rest...) {
+		if len(want) == 0 {
+			break
+		}
+		ks := first
+		if i != 0 {
+			var err error
+			ks, err = r.has(ctx, want, s.cooldown)
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil, err
+				}
+				errs = append(errs, err)
+				continue
+			}
+		}
+		next := want[:0:0]
+		for _, key := range want {
+			if !ks.Has(key) {
+				missing[key] = append(missing[key], r)
+				next = append(next, key)
+				continue
+			}
+			found.Add(key)
+			if dst := missing[key]; len(dst) != 0 {
+				if data, err := r.get(ctx, key, s.cooldown); err == nil {
+					s.repair(ctx, key, data, dst)
+				}
+			}
+		}
+		want = next
+	}
+	if len(want) != 0 && len(errs) != 0 {
+		return nil, errors.Join(errs...)
+	}
+	return found, nil
+}
+
+// Put implements a method of [blob.KV]. If opts.Replace is false and the key
+// exists in every replica that accepts the write, Put reports
+// [blob.ErrKeyExists]; a replica that already has the key counts toward the
+// write quorum. If opts.Replace is true, every replica must accept the write.
+// If not enough replicas accept the write, Put reports an error wrapping
+// [ErrNoQuorum].
+func (s *ReplicatedKV) Put(ctx context.Context, opts blob.PutOptions) error {
+	errs := s.writeAll(func(r *replica) error {
+		return r.observe(time.Now(), r.kv.Put(ctx, opts), s.cooldown)
+	})
+	quorum := s.quorum
+	if opts.Replace {
+		quorum = len(s.reps)
+	}
+	return s.checkQuorum("put", opts.Key, quorum, errs, blob.IsKeyExists, blob.KeyExists)
+}
+
+// Delete implements a method of [blob.KV]. Every replica must accept the
+// delete; a replica that does not have the key counts as accepting it. If the
+// key is not found in any replica, Delete reports [blob.ErrKeyNotFound]. If
+// any replica fails, Delete reports an error wrapping [ErrNoQuorum], and the
+// key may remain in some replicas.
+func (s *ReplicatedKV) Delete(ctx context.Context, key string) error {
+	errs := s.writeAll(func(r *replica) error {
+		return r.observe(time.Now(), r.kv.Delete(ctx, key), s.cooldown)
+	})
+	return s.checkQuorum("delete", key, len(s.reps), errs, blob.IsKeyNotFound, blob.KeyNotFound)
+}
+
+// List implements a method of [blob.KV]. It reports the union of the keys of
+// all the replicas that respond, in order. A replica that fails is skipped and
+// marked unhealthy; List reports an error only if every replica fails.
+func (s *ReplicatedKV) List(ctx context.Context, start string) iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		var errs []error
+		seqs := make([]iter.Seq2[string, error], len(s.reps))
+		for i, r := range s.reps {
+			seqs[i] = func(yield func(string, error) bool) {
+				begin := time.Now()
+				for key, err := range r.kv.List(ctx, start) {
+					if err != nil {
+						errs = append(errs, r.observe(begin, err, s.cooldown))
+						return
+					}
+					if !yield(key, nil) {
+						return
+					}
+				}
+			}
+		}
+		for key, err := range mergeLists(seqs) {
+			if !yield(key, err) {
+				return
+			}
+		}
+		if len(errs) == len(s.reps) {
+			yield("", errors.Join(errs...))
+		}
+	}
+}
+
+// Len implements a method of [blob.KV]. It reports the largest number of keys
+// in any replica that responds, which may be less than the number of keys
+// reported by List if the replicas differ.
+func (s *ReplicatedKV) Len(ctx context.Context) (int64, error) {
+	var most int64
+	var errs []error
+	for _, r := range s.reps {
+		n, err := r.kv.Len(ctx)
+		if err != nil {
+			errs = append(errs, err)
+		} else if n > most {
+			most = n
+		}
+	}
+	if len(errs) == len(s.reps) {
+		return 0, errors.Join(errs...)
+	}
+	return most, nil
+}
+
+// writeAll calls write concurrently for each replica, and returns the errors
+// reported for each.
+func (s *ReplicatedKV) writeAll(write func(*replica) error) []error {
+	errs := make([]error, len(s.reps))
+	var wg sync.WaitGroup
+	for i, r := range s.reps {
+		wg.Go(func() { errs[i] = write(r) })
+	}
+	wg.Wait()
+	return errs
+}
+
+// checkQuorum reports whether the results of a write to all replicas reached
+// the given quorum. Replicas reporting an error satisfying benign count toward
+// the quorum, but if no replica reported success, the result is noop(key).
+func (s *ReplicatedKV) checkQuorum(op, key string, quorum int, errs []error, benign func(error) bool, noop func(string) error) error {
+	var ok, nops int
+	var failed []error
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			ok++
+		case benign(err):
+			nops++
+		default:
+			failed = append(failed, err)
+		}
+	}
+	if ok+nops < quorum {
+		return fmt.Errorf("%s %q: %w (%d of %d replicas, need %d): %w",
+			op, key, ErrNoQuorum, ok+nops, len(s.reps), quorum, errors.Join(failed...))
+	} else if ok == 0 {
+		return noop(key)
+	}
+	return nil
+}
+
+// repair stores data as the value of key in each of the replicas in dst,
+// which were found to be missing it. Errors are ignored, since the value is
+// already available from another replica.
+func (s *ReplicatedKV) repair(ctx context.Context, key string, data []byte, dst []*replica) {
+	for _, r := range dst {
+		err := r.observe(time.Now(), r.kv.Put(ctx, blob.PutOptions{Key: key, Data: data}), s.cooldown)
+		if err == nil {
+			s.repaired.Add(1)
+		}
+	}
+}
+
+// hedge calls call for each of reps in order, until one succeeds. The next
+// call begins when the previous one fails or, if delay > 0, when delay has
+// elapsed since it began. Calls still pending when one succeeds are cancelled.
+//
+// hedge returns the result of the successful call and the index of its
+// replica, or -1 if no call succeeded, along with the errors reported by the
+// calls that failed, indexed by replica.
+func hedge[T any](ctx context.Context, delay time.Duration, reps []*replica, call func(context.Context, *replica) (T, error)) (T, int, []error) {
+	hctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		i   int
+		v   T
+		err error
+	}
+	results := make(chan result, len(reps))
+	errs := make([]error, len(reps))
+
+	var timer <-chan time.Time
+	next, pending := 0, 0
+	launch := func() {
+		i := next
+		go func() {
+			v, err := call(hctx, reps[i])
+			results <- result{i: i, v: v, err: err}
+		}()
+		next++
+		pending++
+		timer = nil
+		if delay > 0 && next < len(reps) {
+			timer = time.After(delay)
+		}
+	}
+
+	launch()
+	for pending > 0 {
+		select {
+		case <-timer:
+			launch()
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				return r.v, r.i, errs
+			}
+			errs[r.i] = r.err
+			if next < len(reps) && ctx.Err() == nil {
+				launch()
+			}
+		}
+	}
+	var zero T
+	return zero, -1, errs
+}
+
+// order returns the replicas of s in the order they should be tried for a
+// read: Healthy replicas before failed ones, and faster before slower.
+func (s *ReplicatedKV) order() []*replica {
+	now := time.Now().UnixNano()
+	type entry struct {
+		r       *replica
+		down    bool
+		latency int64
+	}
+	es := make([]entry, len(s.reps))
+	for i, r := range s.reps {
+		es[i] = entry{r: r, down: r.downUntil.Load() > now, latency: r.latency.Load()}
+	}
+	slices.SortStableFunc(es, func(a, b entry) int {
+		if a.down != b.down {
+			if a.down {
+				return 1
+			}
+			return -1
+		}
+		return int(min(max(a.latency-b.latency, -1), 1))
+	})
+	out := make([]*replica, len(es))
+	for i, e := range es {
+		out[i] = e.r
+	}
+	return out
+}
+
+// A replica is a keyspace of a [ReplicatedKV], with its health.
+type replica struct {
+	kv        blob.KV
+	latency   atomic.Int64 // moving average of successful call latency (ns)
+	downUntil atomic.Int64 // Unix time (ns) before which the replica is unhealthy
+}
+
+func (r *replica) get(ctx context.Context, key string, cooldown time.Duration) ([]byte, error) {
+	start := time.Now()
+	data, err := r.kv.Get(ctx, key)
+	return data, r.observe(start, err, cooldown)
+}
+
+func (r *replica) has(ctx context.Context, keys []string, cooldown time.Duration) (blob.KeySet, error) {
+	start := time.Now()
+	ks, err := r.kv.Has(ctx, keys...)
+	return ks, r.observe(start, err, cooldown)
+}
+
+// observe records the outcome of a call to r begun at start, and returns err.
+// A call that fails for a reason other than the key or the caller's context
+// marks r unhealthy for the cooldown period.
+func (r *replica) observe(start time.Time, err error, cooldown time.Duration) error {
+	switch {
+	case err == nil || blob.IsKeyNotFound(err) || blob.IsKeyExists(err):
+		d := int64(time.Since(start))
+		if old := r.latency.Load(); old != 0 {
+			d = (7*old + d) / 8
+		}
+		r.latency.Store(d)
+		r.downUntil.Store(0)
+	case errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded):
+		// The caller gave up; this says nothing about the replica.
+	default:
+		r.downUntil.Store(time.Now().Add(cooldown).UnixNano())
+	}
+	return err
+}
@@ -0,0 +1,327 @@
+package chirpstore_test
+
+import (
+	"context"
+	"errors"
+	"iter"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/creachadair/chirpstore"
+	"github.com/creachadair/ffs/blob"
+	"github.com/creachadair/mds/mapset"
+	"github.com/google/go-cmp/cmp"
+)
+
+var errDown = errors.New("replica is down")
+
+// downKV wraps a KV so that its reads and writes fail while down is set.
+type downKV struct {
+	blob.KV
+	down atomic.Bool
+}
+
+func (d *downKV) Get(ctx context.Context, key string) ([]byte, error) {
+	if d.down.Load() {
+		return nil, errDown
+	}
+	return d.KV.Get(ctx, key)
+}
+
+func (d *downKV) Has(ctx context.Context, keys ...string) (blob.KeySet, error) {
+	if d.down.Load() {
+		return nil, errDown
+	}
+	return d.KV.Has(ctx, keys...)
+}
+
+func (d *downKV) Put(ctx context.Context, opts blob.PutOptions) error {
+	if d.down.Load() {
+		return errDown
+	}
+	return d.KV.Put(ctx, opts)
+}
+
+func (d *downKV) Delete(ctx context.Context, key string) error {
+	if d.down.Load() {
+		return errDown
+	}
+	return d.KV.Delete(ctx, key)
+}
+
+func (d *downKV) List(ctx context.Context, start string) iter.Seq2[string, error] {
+	if d.down.Load() {
+		return func(yield func(string, error) bool) { yield("", errDown) }
+	}
+	return d.KV.List(ctx, start)
+}
+
+func TestReplicatedKV(t *testing.T) {
+	ctx := t.Context()
+	var reps []*downKV
+	var kvs []blob.KV
+	for range 3 {
+		d := &downKV{KV: mustKV(t, chirpstore.NewStore(newTestService(t, nil), nil), "data")}
+		reps = append(reps, d)
+		kvs = append(kvs, d)
+	}
+	if _, err := chirpstore.NewReplicatedKV(kvs, &chirpstore.ReplicaOptions{WriteQuorum: 4}); err == nil {
+		t.Fatal("NewReplicatedKV: quorum larger than replicas was accepted")
+	}
+	rkv, err := chirpstore.NewReplicatedKV(kvs, nil)
+	if err != nil {
+		t.Fatalf("NewReplicatedKV: unexpected error: %v", err)
+	}
+	put := func(key, data string, replace bool) error {
+		return rkv.Put(ctx, blob.PutOptions{Key: key, Data: []byte(data), Replace: replace})
+	}
+	checkGet := func(kv blob.KVCore, key, want string) {
+		t.Helper()
+		if got, err := kv.Get(ctx, key); err != nil || string(got) != want {
+			t.Errorf("Get %q: got (%q, %v), want %q", key, got, err, want)
+		}
+	}
+
+	t.Run("Quorum", func(t *testing.T) {
+		reps[2].down.Store(true)
+		defer reps[2].down.Store(false)
+
+		if err := put("a", "apple", false); err != nil {
+			t.Errorf("Put with one replica down: unexpected error: %v", err)
+		}
+		checkGet(rkv, "a", "apple")
+		if err := put("a", "avocado", false); !blob.IsKeyExists(err) {
+			t.Errorf("Put existing: got %v, want key exists", err)
+		}
+
+		reps[1].down.Store(true)
+		defer reps[1].down.Store(false)
+		if err := put("b", "banana", false); !errors.Is(err, chirpstore.ErrNoQuorum) {
+			t.Errorf("Put with two replicas down: got %v, want %v", err, chirpstore.ErrNoQuorum)
+		}
+	})
+
+	t.Run("ReadRepair", func(t *testing.T) {
+		// Write while replica 2 is down, so that it is marked unhealthy and tried
+		// last, while replicas 0 and 1 are healthy again.
+		reps[2].down.Store(true)
+		if err := put("z", "zucchini", false); err != nil {
+			t.Fatalf("Put: unexpected error: %v", err)
+		}
+		reps[2].down.Store(false)
+
+		// Leave "a" only in replica 2, so reading it repairs the others.
+		for _, d := range reps[:2] {
+			if err := d.KV.Delete(ctx, "a"); err != nil {
+				t.Fatalf("Delete: unexpected error: %v", err)
+			}
+		}
+		if err := reps[2].KV.Put(ctx, blob.PutOptions{Key: "a", Data: []byte("apple")}); err != nil {
+			t.Fatalf("Put: unexpected error: %v", err)
+		}
+		checkGet(rkv, "a", "apple")
+		if got := rkv.Stats().Repaired; got != 2 {
+			t.Errorf("Repaired: got %d, want 2", got)
+		}
+		for i, d := range reps {
+			checkGet(d.KV, "a", "apple")
+			if ks, err := d.KV.Has(ctx, "b"); err != nil {
+				t.Errorf("Replica %d Has: unexpected error: %v", i, err)
+			} else if ks.Has("b") != (i == 0) {
+				t.Errorf("Replica %d Has b: got %v, want %v", i, ks.Has("b"), i == 0)
+			}
+		}
+
+		// Delete "z" while replica 0 is down, so that it is tried last, then
+		// remove "z" from it directly. The delete fails, since every replica
+		// must accept it.
+		reps[0].down.Store(true)
+		if err := rkv.Delete(ctx, "z"); !errors.Is(err, chirpstore.ErrNoQuorum) {
+			t.Errorf("Delete with one replica down: got %v, want %v", err, chirpstore.ErrNoQuorum)
+		}
+		reps[0].down.Store(false)
+		if err := reps[0].KV.Delete(ctx, "z"); err != nil {
+			t.Fatalf("Delete: unexpected error: %v", err)
+		}
+
+		// Has finds "b" only in replica 0, and copies it to the others.
+		if ks, err := rkv.Has(ctx, "a", "b", "c"); err != nil {
+			t.Fatalf("Has: unexpected error: %v", err)
+		} else if !ks.Equals(mapset.New("a", "b")) {
+			t.Errorf("Has: got %v, want [a b]", ks.Slice())
+		}
+		for _, d := range reps {
+			checkGet(d.KV, "b", "banana")
+		}
+		if got := rkv.Stats().Repaired; got != 4 {
+			t.Errorf("Repaired: got %d, want 4", got)
+		}
+	})
+
+	t.Run("List", func(t *testing.T) {
+		if err := reps[1].KV.Put(ctx, blob.PutOptions{Key: "c", Data: []byte("cherry")}); err != nil {
+			t.Fatalf("Put: unexpected error: %v", err)
+		}
+		if diff := cmp.Diff(listKeys(t, rkv), []string{"a", "b", "c"}); diff != "" {
+			t.Errorf("List (-got, +want):\n%s", diff)
+		}
+		if n, err := rkv.Len(ctx); err != nil || n != 3 {
+			t.Errorf("Len: got (%d, %v), want 3", n, err)
+		}
+	})
+
+	t.Run("ListDown", func(t *testing.T) {
+		// Only replica 1 has "c", so listing without it omits "c".
+		defer func() {
+			for _, d := range reps {
+				d.down.Store(false)
+			}
+		}()
+		reps[1].down.Store(true)
+		if diff := cmp.Diff(listKeys(t, rkv), []string{"a", "b"}); diff != "" {
+			t.Errorf("List with one replica down (-got, +want):\n%s", diff)
+		}
+
+		for _, d := range reps {
+			d.down.Store(true)
+		}
+		var got error
+		for _, err := range rkv.List(ctx, "") {
+			got = err
+		}
+		if !errors.Is(got, errDown) {
+			t.Errorf("List with all replicas down: got %v, want %v", got, errDown)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		if err := rkv.Delete(ctx, "c"); err != nil {
+			t.Errorf("Delete: unexpected error: %v", err)
+		}
+		if err := rkv.Delete(ctx, "c"); !blob.IsKeyNotFound(err) {
+			t.Errorf("Delete missing: got %v, want key not found", err)
+		}
+		if _, err := rkv.Get(ctx, "c"); !blob.IsKeyNotFound(err) {
+			t.Errorf("Get deleted: got %v, want key not found", err)
+		}
+	})
+
+	t.Run("DeleteDown", func(t *testing.T) {
+		if err := put("d", "durian", false); err != nil {
+			t.Fatalf("Put: unexpected error: %v", err)
+		}
+		repaired := rkv.Stats().Repaired
+
+		// A delete or replacement that does not reach every replica fails, so
+		// that read repair cannot restore the old value from a replica that
+		// missed it.
+		reps[1].down.Store(true)
+		if err := rkv.Delete(ctx, "d"); !errors.Is(err, chirpstore.ErrNoQuorum) {
+			t.Errorf("Delete with one replica down: got %v, want %v", err, chirpstore.ErrNoQuorum)
+		}
+		if err := put("d", "date", true); !errors.Is(err, chirpstore.ErrNoQuorum) {
+			t.Errorf("Replace with one replica down: got %v, want %v", err, chirpstore.ErrNoQuorum)
+		}
+		reps[1].down.Store(false)
+
+		// Once every replica is available, the delete succeeds, and the key is
+		// not restored.
+		if err := rkv.Delete(ctx, "d"); err != nil {
+			t.Errorf("Delete: unexpected error: %v", err)
+		}
+		if got, err := rkv.Get(ctx, "d"); !blob.IsKeyNotFound(err) {
+			t.Errorf("Get deleted: got (%q, %v), want key not found", got, err)
+		}
+		if got := rkv.Stats().Repaired; got != repaired {
+			t.Errorf("Repaired: got %d, want %d", got, repaired)
+		}
+		for i, d := range reps {
+			if _, err := d.KV.Get(ctx, "d"); !blob.IsKeyNotFound(err) {
+				t.Errorf("Replica %d Get deleted: got %v, want key not found", i, err)
+			}
+		}
+	})
+}
+
+// slowKV wraps a KV so that its reads take delay, and signals cancelled when a
+// read is cancelled.
+type slowKV struct {
+	blob.KV
+	delay     time.Duration
+	cancelled chan struct{}
+}
+
+func (s *slowKV) wait(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		s.cancelled <- struct{}{}
+		return ctx.Err()
+	case <-time.After(s.delay):
+		return nil
+	}
+}
+
+func (s *slowKV) Get(ctx context.Context, key string) ([]byte, error) {
+	if err := s.wait(ctx); err != nil {
+		return nil, err
+	}
+	return s.KV.Get(ctx, key)
+}
+
+func (s *slowKV) Has(ctx context.Context, keys ...string) (blob.KeySet, error) {
+	if err := s.wait(ctx); err != nil {
+		return nil, err
+	}
+	return s.KV.Has(ctx, keys...)
+}
+
+func TestHedgedReads(t *testing.T) {
+	ctx := t.Context()
+
+	// Replica 0 is tried first, since neither has any history, but is slow.
+	slow := &slowKV{
+		KV:        mustKV(t, chirpstore.NewStore(newTestService(t, nil), nil), "data"),
+		delay:     time.Minute,
+		cancelled: make(chan struct{}, 1),
+	}
+	fast := mustKV(t, chirpstore.NewStore(newTestService(t, nil), nil), "data")
+	for _, kv := range []blob.KV{slow.KV, fast} {
+		if err := kv.Put(ctx, blob.PutOptions{Key: "k", Data: []byte("v")}); err != nil {
+			t.Fatalf("Put: unexpected error: %v", err)
+		}
+	}
+	newKV := func() *chirpstore.ReplicatedKV {
+		rkv, err := chirpstore.NewReplicatedKV([]blob.KV{slow, fast}, &chirpstore.ReplicaOptions{
+			HedgeDelay: 10 * time.Millisecond,
+		})
+		if err != nil {
+			t.Fatalf("NewReplicatedKV: unexpected error: %v", err)
+		}
+		return rkv
+	}
+
+	t.Run("Get", func(t *testing.T) {
+		if got, err := newKV().Get(ctx, "k"); err != nil || string(got) != "v" {
+			t.Errorf("Get: got (%q, %v), want v", got, err)
+		}
+		select {
+		case <-slow.cancelled:
+		case <-time.After(5 * time.Second):
+			t.Error("Slow Get was not cancelled")
+		}
+	})
+
+	t.Run("Has", func(t *testing.T) {
+		if ks, err := newKV().Has(ctx, "k"); err != nil {
+			t.Errorf("Has: unexpected error: %v", err)
+		} else if !ks.Equals(mapset.New("k")) {
+			t.Errorf("Has: got %v, want [k]", ks.Slice())
+		}
+		select {
+		case <-slow.cancelled:
+		case <-time.After(5 * time.Second):
+			t.Error("Slow Has was not cancelled")
+		}
+	})
+}
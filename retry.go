@@ -0,0 +1,110 @@
+package chirpstore
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net"
+	"time"
+
+	"github.com/creachadair/chirp"
+)
+
+// A RetryPolicy controls how a [KV] retries calls to the service that fail
+// with a transient error. A call is retried only if it is idempotent: Get,
+// Has, List, Len, and Digest are always retried, and Put is retried only if
+// its Replace option is set. Other calls are never retried.
+//
+// An error is transient if it is [ErrUnavailable], or if it is a network
+// timeout reported by the channel of the peer. Errors reported by the service,
+// such as a missing key, are not transient. Nor are other transport errors,
+// such as a closed peer or a malformed call, since repeating the call on the
+// same peer cannot succeed, nor the end of the caller's context.
+//
+// Between attempts, the client waits for an exponentially increasing delay,
+// with random jitter, while the caller's context permits.
+type RetryPolicy struct {
+	// The maximum number of attempts for each call, including the first.
+	// If zero, a default limit is used.
+	MaxAttempts int
+
+	// The delay before the first retry. Each later delay is twice the one
+	// before it, up to MaxBackoff. The actual delay is chosen at random
+	// between half the nominal delay and the nominal delay.
+	// If zero, a default value is used.
+	MinBackoff time.Duration
+
+	// The maximum delay between attempts.
+	// If zero, a default value is used.
+	MaxBackoff time.Duration
+}
+
+func (r *RetryPolicy) maxAttempts() int {
+	if r == nil {
+		return 1
+	} else if r.MaxAttempts <= 0 {
+		return 3
+	}
+	return r.MaxAttempts
+}
+
+func (r *RetryPolicy) minBackoff() time.Duration {
+	if r == nil || r.MinBackoff <= 0 {
+		return 50 * time.Millisecond
+	}
+	return r.MinBackoff
+}
+
+func (r *RetryPolicy) maxBackoff() time.Duration {
+	if r == nil || r.MaxBackoff <= 0 {
+		return 5 * time.Second
+	}
+	return r.MaxBackoff
+}
+
+// backoff returns a randomized delay to wait after the given attempt (from 1).
+func (r *RetryPolicy) backoff(attempt int) time.Duration {
+	d := r.minBackoff()
+	for i := 1; i < attempt && d < r.maxBackoff(); i++ {
+		d *= 2
+	}
+	d = min(d, r.maxBackoff())
+	return d/2 + rand.N(d/2+1)
+}
+
+// call calls method m of the service with the given data. If idempotent is
+// true, transient failures are retried according to the retry policy of s.
+// The error reported by call has not been filtered by unfilterErr.
+func (s KV) call(ctx context.Context, m string, data []byte, idempotent bool) (*chirp.Response, error) {
+	attempts := 1
+	if idempotent {
+		attempts = s.retry.maxAttempts()
+	}
+	for i := 1; ; i++ {
+		rsp, err := s.stripe.pick(s.peer).Call(ctx, s.method(m), data)
+		if err == nil || i >= attempts || !isTransient(err) {
+			return rsp, err
+		}
+		t := time.NewTimer(s.retry.backoff(i))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil, err
+		case <-t.C:
+		}
+	}
+}
+
+// isTransient reports whether err, from a call to the service, is transient.
+func isTransient(err error) bool {
+	ce, ok := errors.AsType[*chirp.CallError](err)
+	if !ok {
+		return false
+	} else if ce.Err == nil {
+		return ce.Code == codeUnavailable // a service error
+	} else if errors.Is(ce.Err, context.Canceled) || errors.Is(ce.Err, context.DeadlineExceeded) {
+		return false
+	}
+	ne, ok := errors.AsType[net.Error](ce.Err)
+	return ok && ne.Timeout()
+}
@@ -0,0 +1,88 @@
+package chirpstore_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/creachadair/chirp"
+	"github.com/creachadair/chirpstore"
+	"github.com/creachadair/ffs/blob"
+	"github.com/creachadair/ffs/blob/memstore"
+)
+
+// flakyService returns a peer for a service whose get and put methods fail as
+// unavailable while *fails is positive, decrementing it each time, and counts
+// the calls to those methods in *calls.
+func flakyService(t *testing.T, fails, calls *atomic.Int64) *chirp.Peer {
+	t.Helper()
+	return newTestService(t, chirpstore.NewService(memstore.New(nil), &chirpstore.ServiceOptions{
+		Authorize: func(_ context.Context, method string) error {
+			if method != "get" && method != "put" {
+				return nil
+			}
+			calls.Add(1)
+			if fails.Add(-1) >= 0 {
+				// The code that clients report as chirpstore.ErrUnavailable.
+				return &chirp.ErrorData{Code: 503, Message: "try again"}
+			}
+			return nil
+		},
+	}))
+}
+
+func TestRetry(t *testing.T) {
+	ctx := t.Context()
+	var fails, calls atomic.Int64
+	rs := chirpstore.NewStore(flakyService(t, &fails, &calls), &chirpstore.StoreOptions{
+		Retry: &chirpstore.RetryPolicy{MaxAttempts: 3, MinBackoff: time.Millisecond},
+	})
+	kv := mustKV(t, rs, "retry")
+
+	check := func(name string, nfail int, f func() error, wantErr error, wantCalls int64) {
+		t.Helper()
+		fails.Store(int64(nfail))
+		calls.Store(0)
+		if err := f(); !errors.Is(err, wantErr) {
+			t.Errorf("%s: got error %v, want %v", name, err, wantErr)
+		}
+		if got := calls.Load(); got != wantCalls {
+			t.Errorf("%s: got %d calls, want %d", name, got, wantCalls)
+		}
+	}
+	put := func(replace bool) func() error {
+		return func() error {
+			return kv.Put(ctx, blob.PutOptions{Key: "k", Data: []byte("v"), Replace: replace})
+		}
+	}
+	get := func() error { _, err := kv.Get(ctx, "k"); return err }
+
+	check("Put/NoReplace", 1, put(false), chirpstore.ErrUnavailable, 1)
+	check("Put/Replace", 2, put(true), nil, 3)
+	check("Get/Recover", 2, get, nil, 3)
+	check("Get/Exhausted", 3, get, chirpstore.ErrUnavailable, 3)
+
+	if err := kv.Delete(ctx, "k"); err != nil {
+		t.Fatalf("Delete: unexpected error: %v", err)
+	}
+	check("Get/NotFound", 0, get, blob.ErrKeyNotFound, 1)
+}
+
+func TestRetryClosed(t *testing.T) {
+	// A call on a closed peer is not retried, since it cannot succeed.
+	peer := newTestService(t, nil)
+	kv := mustKV(t, chirpstore.NewStore(peer, &chirpstore.StoreOptions{
+		Retry: &chirpstore.RetryPolicy{MaxAttempts: 3, MinBackoff: time.Minute},
+	}), "retry")
+	peer.Stop()
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+	if got, err := kv.Get(ctx, "k"); err == nil {
+		t.Errorf("Get: got %q, want error", got)
+	} else if ctx.Err() != nil {
+		t.Errorf("Get: retried until the context ended: %v", err)
+	}
+}
@@ -0,0 +1,166 @@
+package chirpstore
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"slices"
+	"time"
+
+	"github.com/creachadair/chirp"
+	"github.com/creachadair/chirp/channel"
+	"github.com/creachadair/ffs/blob"
+	"github.com/creachadair/taskgroup"
+)
+
+// Serve accepts connections from lst and serves each on a new peer with the
+// methods of s registered, until ctx ends or lst is closed. All the peers
+// started by Serve share the keyspace and substore IDs of s.
+//
+// When ctx ends, Serve closes lst, stops the peers it started, and waits for
+// them to exit. If lst is closed by the caller, Serve waits for its peers to
+// exit without stopping them. In either case, Serve reports nil. If Accept
+// fails for any other reason, such as the process running out of file
+// descriptors, Serve waits briefly and tries again, backing off if the errors
+// persist.
+func (s *Service) Serve(ctx context.Context, lst net.Listener) error {
+	// A net.Listener does not obey a context, so close it when ctx ends.
+	stop := context.AfterFunc(ctx, func() { lst.Close() })
+	defer stop()
+
+	var g taskgroup.Group
+	var delay time.Duration // backoff after a failed Accept
+	for {
+		conn, err := lst.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) || ctx.Err() != nil {
+				g.Wait()
+				return nil
+			}
+			delay = min(max(2*delay, minAcceptDelay), maxAcceptDelay)
+			select {
+			case <-ctx.Done():
+			case <-time.After(delay):
+			}
+			continue
+		}
+		delay = 0
+		g.Go(func() error {
+			s.ServeChannel(ctx, channel.IO(conn, conn))
+			return nil
+		})
+	}
+}
+
+// The bounds of the delay before retrying a failed Accept in Serve.
+const (
+	minAcceptDelay = 5 * time.Millisecond
+	maxAcceptDelay = time.Second
+)
+
+// ServeChannel serves a single peer on ch with the methods of s registered,
+// until ctx ends or ch is closed. When ctx ends, ServeChannel stops the peer
+// and waits for it to exit. It reports the error from the peer, if any.
+//
+// The method handlers for the peer receive the values of ctx, including the
+// identity of the remote peer if ch is a TLS connection (see [PeerCertificate]).
+//
+// For example, to serve a peer on stdin and stdout:
+//
+//	err := s.ServeChannel(ctx, channel.IO(os.Stdin, os.Stdout))
+func (s *Service) ServeChannel(ctx context.Context, ch chirp.Channel) error {
+	if conn, ok := channel.NetConn(ch).(*tls.Conn); ok {
+		// The handshake may not be complete yet, so defer checking the state.
+		ctx = withTLSState(ctx, conn.ConnectionState)
+	}
+	peer := s.startPeer(ctx, ch)
+	defer s.dropPeer(peer)
+
+	// If ctx ends, stop the peer. Clean up the stop function if the peer ends
+	// before ctx, however, since ctx may run for a long time, and we do not
+	// want dead stop callbacks to pile up.
+	done := context.AfterFunc(ctx, func() { peer.Stop() })
+	defer done()
+	return peer.Wait()
+}
+
+// Shutdown drains s and closes its backing stores. Once Shutdown is called,
+// s rejects all new calls, and clients receive [ErrUnavailable]. Shutdown then
+// waits for the calls in flight to finish, and closes each backing store or
+// substore that implements [blob.StoreCloser].
+//
+// If ctx ends before the calls in flight are finished, Shutdown reports the
+// error from ctx without closing the stores; the caller may call Shutdown
+// again to resume waiting. Shutdown does not stop the peers started by
+// [Service.Serve]; the caller should end the context passed to Serve after
+// Shutdown returns.
+func (s *Service) Shutdown(ctx context.Context) error {
+	s.μ.Lock()
+	s.draining = true
+	s.μ.Unlock()
+
+	// Wait for the calls in flight in a single goroutine, shared by all calls
+	// to Shutdown, so that a call that gives up does not leave it behind.
+	s.drainOnce.Do(func() {
+		s.drained = make(chan struct{})
+		go func() { defer close(s.drained); s.active.Wait() }()
+	})
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-s.drained:
+	}
+	s.closeOnce.Do(func() { s.closeErr = s.closeStores(ctx) })
+	return s.closeErr
+}
+
+// closeStores closes the stores of s that implement [blob.StoreCloser].
+// Substores are closed before the stores that contain them.
+func (s *Service) closeStores(ctx context.Context) error {
+	s.μ.Lock()
+	defer s.μ.Unlock()
+
+	// A substore is always assigned a larger ID than its parent.
+	var errs []error
+	for _, id := range slices.Backward(slices.Sorted(maps.Keys(s.subs))) {
+		if sc, ok := s.subs[id].store.(blob.StoreCloser); ok {
+			if err := sc.Close(ctx); err != nil {
+				errs = append(errs, fmt.Errorf("close store %d: %w", id, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// startPeer starts a new peer on ch with the methods of s registered, and
+// adds it to the set of live peers. The handlers for the peer receive the
+// values of ctx, but not its deadline or cancellation.
+func (s *Service) startPeer(ctx context.Context, ch chirp.Channel) *chirp.Peer {
+	peer := chirp.NewPeer()
+	s.Register(peer)
+	if s.plog != nil {
+		peer.LogPackets(s.plog)
+	}
+	peer.NewContext(func() context.Context { return context.WithoutCancel(ctx) })
+	s.μ.Lock()
+	s.peers[peer] = struct{}{}
+	s.μ.Unlock()
+	return peer.Start(ch)
+}
+
+// dropPeer removes peer from the set of live peers.
+func (s *Service) dropPeer(peer *chirp.Peer) {
+	s.μ.Lock()
+	defer s.μ.Unlock()
+	delete(s.peers, peer)
+}
+
+// numPeers reports the number of live peers started by Serve.
+func (s *Service) numPeers() int {
+	s.μ.Lock()
+	defer s.μ.Unlock()
+	return len(s.peers)
+}
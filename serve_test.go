@@ -0,0 +1,265 @@
+package chirpstore_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/creachadair/chirp"
+	"github.com/creachadair/chirp/channel"
+	"github.com/creachadair/chirpstore"
+	"github.com/creachadair/ffs/blob"
+	"github.com/creachadair/ffs/blob/memstore"
+)
+
+// startServer runs svc on a local listener until the test ends, and returns
+// the address of the listener.
+func startServer(t *testing.T, svc *chirpstore.Service) net.Addr {
+	t.Helper()
+	lst, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- svc.Serve(ctx, lst) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("Serve: unexpected error: %v", err)
+		}
+	})
+	return lst.Addr()
+}
+
+func dialStore(t *testing.T, addr net.Addr) chirpstore.Store {
+	t.Helper()
+	conn, err := net.Dial(addr.Network(), addr.String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	peer := chirp.NewPeer().Start(channel.IO(conn, conn))
+	t.Cleanup(func() { peer.Stop() })
+	return chirpstore.NewStore(peer, nil)
+}
+
+func livePeers(t *testing.T, kv chirpstore.KV) int {
+	t.Helper()
+	data, err := kv.Status(t.Context())
+	if err != nil {
+		t.Fatalf("Status: unexpected error: %v", err)
+	}
+	var status struct {
+		Store struct {
+			Peers int `json:"peers"`
+		} `json:"store"`
+	}
+	if err := json.Unmarshal(data, &status); err != nil {
+		t.Fatalf("Decode status: %v", err)
+	}
+	return status.Store.Peers
+}
+
+// flakyListener is a net.Listener whose Accept fails the first fails times.
+type flakyListener struct {
+	net.Listener
+	fails atomic.Int32
+}
+
+func (f *flakyListener) Accept() (net.Conn, error) {
+	if f.fails.Add(-1) >= 0 {
+		return nil, errors.New("too many open files")
+	}
+	return f.Listener.Accept()
+}
+
+func TestServeRetry(t *testing.T) {
+	lst, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	flaky := &flakyListener{Listener: lst}
+	flaky.fails.Store(3)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- chirpstore.NewService(memstore.New(nil), nil).Serve(ctx, flaky) }()
+
+	// The service keeps accepting connections after Accept fails.
+	kv := mustKV(t, dialStore(t, lst.Addr()), "test")
+	if err := kv.Put(t.Context(), blob.PutOptions{Key: "k", Data: []byte("v")}); err != nil {
+		t.Errorf("Put: unexpected error: %v", err)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Serve: unexpected error: %v", err)
+	}
+}
+
+func TestServe(t *testing.T) {
+	addr := startServer(t, chirpstore.NewService(memstore.New(nil), nil))
+	ctx := t.Context()
+
+	a := mustKV(t, dialStore(t, addr), "shared")
+	b := mustKV(t, dialStore(t, addr), "shared")
+
+	if err := a.Put(ctx, blob.PutOptions{Key: "hello", Data: []byte("world")}); err != nil {
+		t.Fatalf("Put: unexpected error: %v", err)
+	}
+	if got, err := b.Get(ctx, "hello"); err != nil {
+		t.Errorf("Get: unexpected error: %v", err)
+	} else if string(got) != "world" {
+		t.Errorf("Get: got %q, want %q", got, "world")
+	}
+
+	if got := livePeers(t, a); got != 2 {
+		t.Errorf("Live peers: got %d, want 2", got)
+	}
+}
+
+// drainStore is a blob.StoreCloser whose keyspaces block Put until released,
+// and which records when it has been closed.
+type drainStore struct {
+	blob.StoreCloser
+	entered, release chan struct{}
+	closed           atomic.Bool
+}
+
+func (d *drainStore) KV(ctx context.Context, name string) (blob.KV, error) {
+	kv, err := d.StoreCloser.KV(ctx, name)
+	return drainKV{KV: kv, d: d}, err
+}
+
+func (d *drainStore) Close(ctx context.Context) error {
+	d.closed.Store(true)
+	return d.StoreCloser.Close(ctx)
+}
+
+type drainKV struct {
+	blob.KV
+	d *drainStore
+}
+
+func (k drainKV) Put(ctx context.Context, opts blob.PutOptions) error {
+	k.d.entered <- struct{}{}
+	<-k.d.release
+	return k.KV.Put(ctx, opts)
+}
+
+func TestShutdown(t *testing.T) {
+	ds := &drainStore{
+		StoreCloser: memstore.New(nil),
+		entered:     make(chan struct{}),
+		release:     make(chan struct{}),
+	}
+	svc := chirpstore.NewService(ds, nil)
+	ctx := t.Context()
+	kv := mustKV(t, chirpstore.NewStore(newTestService(t, svc), nil), "test")
+
+	// Start a put that will be in flight when shutdown begins.
+	putErr := make(chan error, 1)
+	go func() { putErr <- kv.Put(ctx, blob.PutOptions{Key: "k", Data: []byte("v")}) }()
+	<-ds.entered
+
+	t.Run("Timeout", func(t *testing.T) {
+		tctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+		if err := svc.Shutdown(tctx); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Shutdown: got %v, want %v", err, context.DeadlineExceeded)
+		}
+		if ds.closed.Load() {
+			t.Error("Store was closed with a call in flight")
+		}
+
+		// Shutdown calls that time out do not leave goroutines behind.
+		n := runtime.NumGoroutine()
+		for range 5 {
+			tctx, cancel := context.WithTimeout(ctx, time.Millisecond)
+			svc.Shutdown(tctx)
+			cancel()
+		}
+		if got := runtime.NumGoroutine(); got > n {
+			t.Errorf("Goroutines after repeated Shutdown: got %d, want at most %d", got, n)
+		}
+	})
+	t.Run("Unavailable", func(t *testing.T) {
+		got, err := kv.Get(ctx, "k")
+		if !errors.Is(err, chirpstore.ErrUnavailable) {
+			t.Errorf("Get: got (%q, %v), want %v", got, err, chirpstore.ErrUnavailable)
+		}
+		if _, err := kv.Has(ctx, "k"); !errors.Is(err, chirpstore.ErrUnavailable) {
+			t.Errorf("Has: got %v, want %v", err, chirpstore.ErrUnavailable)
+		}
+		for _, err := range kv.List(ctx, "") {
+			if !errors.Is(err, chirpstore.ErrUnavailable) {
+				t.Errorf("List: got %v, want %v", err, chirpstore.ErrUnavailable)
+			}
+		}
+		if _, err := kv.Len(ctx); !errors.Is(err, chirpstore.ErrUnavailable) {
+			t.Errorf("Len: got %v, want %v", err, chirpstore.ErrUnavailable)
+		}
+	})
+	t.Run("Drain", func(t *testing.T) {
+		close(ds.release)
+		if err := <-putErr; err != nil {
+			t.Errorf("Put: unexpected error: %v", err)
+		}
+		if err := svc.Shutdown(ctx); err != nil {
+			t.Errorf("Shutdown: unexpected error: %v", err)
+		}
+		if !ds.closed.Load() {
+			t.Error("Store was not closed after shutdown")
+		}
+	})
+}
+
+// If this variable is set in the environment, the test binary serves a memory
+// store on stdin and stdout instead of running tests. See TestStdio.
+const stdioHelperEnv = "CHIRPSTORE_TEST_STDIO_SERVER"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(stdioHelperEnv) != "" {
+		svc := chirpstore.NewService(memstore.New(nil), nil)
+		if err := svc.ServeChannel(context.Background(), channel.IO(os.Stdin, os.Stdout)); err != nil {
+			log.Fatalf("ServeChannel: %v", err)
+		}
+		return
+	}
+	m.Run()
+}
+
+func TestStdio(t *testing.T) {
+	cmd := exec.Command(os.Args[0])
+	cmd.Env = append(os.Environ(), stdioHelperEnv+"=1")
+	cmd.Stderr = os.Stderr
+	peer, err := chirpstore.StartCommand(cmd)
+	if err != nil {
+		t.Fatalf("StartCommand: %v", err)
+	}
+
+	ctx := t.Context()
+	kv := mustKV(t, chirpstore.NewStore(peer, nil), "test")
+	if err := kv.Put(ctx, blob.PutOptions{Key: "hello", Data: []byte("world")}); err != nil {
+		t.Fatalf("Put: unexpected error: %v", err)
+	}
+	if got, err := kv.Get(ctx, "hello"); err != nil {
+		t.Errorf("Get: unexpected error: %v", err)
+	} else if string(got) != "world" {
+		t.Errorf("Get: got %q, want %q", got, "world")
+	}
+
+	if err := peer.Stop(); err != nil {
+		t.Errorf("Stop: unexpected error: %v", err)
+	}
+	if !cmd.ProcessState.Success() {
+		t.Errorf("Server exited with %v", cmd.ProcessState)
+	}
+}
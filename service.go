@@ -3,17 +3,24 @@ package chirpstore
 import (
 	"context"
 	"errors"
+	"expvar"
 	"fmt"
+	"maps"
+	"slices"
+	"strings"
 	"sync"
 
 	"github.com/creachadair/chirp"
+	"github.com/creachadair/chirp/packet"
 	"github.com/creachadair/ffs/blob"
 )
 
 // Constants defining the method names for the store service.
 const (
 	// Metadata.
-	mStatus = "status"
+	mStatus    = "status"
+	mCodecs    = "codecs"
+	mChecksums = "checksums"
 
 	// Keyspace (KV) methods.
 	mGet    = "get"
@@ -22,29 +29,75 @@ const (
 	mDelete = "delete"
 	mList   = "list"
 	mLen    = "len"
+	mDrop   = "drop"
+	mCopy   = "copy"
+	mMove   = "move"
+	mDigest = "digest"
+	mMGet   = "mget"
+	mZGet   = "zget"
+	mCGet   = "cget"
 
 	// Store methods.
-	mKV  = "kv"
-	mCAS = "cas" // alias for mKV
-	mSub = "sub"
+	mKV        = "kv"
+	mCAS       = "cas" // alias for mKV
+	mSub       = "sub"
+	mKeyspaces = "keyspaces"
+	mSubstores = "substores"
 )
 
 type Service struct {
-	pfx string
+	pfx  string
+	plog chirp.PacketLogger
+	auth func(context.Context, string) error
+	mx   *expvar.Map
 
-	μ      sync.Mutex
-	lastID int
-	subs   map[int]*storeInfo
-	kvs    map[int]blob.KV
+	zopts  *CompressionOptions // if non-nil, compress values sent by zget
+	zstore bool                // store values as CodedValue
+
+	maxValue int64 // maximum size of a decompressed value
+
+	// Metrics for drop requests.
+	dropsActive expvar.Int
+	dropKeys    expvar.Int
+
+	μ       sync.Mutex
+	lastID  int
+	subs    map[int]*storeInfo
+	kvs     map[int]blob.KV
+	kvNames map[int]string           // keyspace ID to name
+	peers   map[*chirp.Peer]struct{} // live peers started by Serve
+
+	// Shutdown state. Once draining is set, no new calls are admitted, and
+	// active counts the handlers still running. The drained channel is closed
+	// once they have all finished.
+	draining  bool
+	active    sync.WaitGroup
+	drainOnce sync.Once
+	drained   chan struct{}
+	closeOnce sync.Once
+	closeErr  error
 }
 
 // NewService constructs a service that delegates to the given [blob.KV].
 func NewService(st blob.Store, opts *ServiceOptions) *Service {
 	s := &Service{
-		pfx:  opts.prefix(),
-		subs: map[int]*storeInfo{0: newStoreInfo(st)},
-		kvs:  make(map[int]blob.KV),
+		pfx:      opts.prefix(),
+		plog:     opts.packetLogger(),
+		auth:     opts.authorize(),
+		zopts:    opts.compression(),
+		zstore:   opts.storeCompressed(),
+		maxValue: opts.maxValueSize(),
+		mx:       new(expvar.Map),
+		subs:     map[int]*storeInfo{0: newStoreInfo(st)},
+		kvs:      make(map[int]blob.KV),
+		kvNames:  make(map[int]string),
+		peers:    make(map[*chirp.Peer]struct{}),
 	}
+	s.mx.Set("keyspaces", expvar.Func(func() any { n, _ := s.numOpen(); return n }))
+	s.mx.Set("substores", expvar.Func(func() any { _, n := s.numOpen(); return n }))
+	s.mx.Set("peers", expvar.Func(func() any { return s.numPeers() }))
+	s.mx.Set("drops_active", &s.dropsActive)
+	s.mx.Set("drop_keys_deleted", &s.dropKeys)
 	return s
 }
 
@@ -62,6 +115,35 @@ func newStoreInfo(st blob.Store) *storeInfo {
 type ServiceOptions struct {
 	// A prefix to prepend to all the method names exported by the service.
 	Prefix string
+
+	// A packet logger to attach to the peers started by [Service.Serve].
+	PacketLogger chirp.PacketLogger
+
+	// If set, this function is called before each method handler with the
+	// context of the call and the name of the method (without prefix).
+	// If it reports an error, the call fails with that error.  The context
+	// carries the identity of the caller, if known (see [PeerCertificate]).
+	Authorize func(ctx context.Context, method string) error
+
+	// If set, values sent to clients that accept compression are compressed
+	// when that makes them smaller. Clients may send compressed values to the
+	// service whether or not this is set.
+	Compression *CompressionOptions
+
+	// If true, the service stores values in the underlying store with a
+	// one-byte header giving their codec, compressed when that makes them
+	// smaller (using Compression, or default settings if it is nil). Values
+	// sent compressed by clients are stored without being decompressed.
+	// Since stored values are not plain, this setting must be the same each
+	// time a given store is served, and the store must not be shared with
+	// other users.
+	StoreCompressed bool
+
+	// The maximum size in bytes of a value sent compressed by a client, once
+	// decompressed. A value that would exceed this is rejected by Put. Values
+	// sent plain, and values the service reads back from storage, are not
+	// limited. If zero, a default of 64 MiB is used.
+	MaxValueSize int64
 }
 
 func (o *ServiceOptions) prefix() string {
@@ -71,20 +153,86 @@ func (o *ServiceOptions) prefix() string {
 	return o.Prefix
 }
 
+func (o *ServiceOptions) packetLogger() chirp.PacketLogger {
+	if o != nil {
+		return o.PacketLogger
+	}
+	return nil
+}
+
+func (o *ServiceOptions) authorize() func(context.Context, string) error {
+	if o != nil {
+		return o.Authorize
+	}
+	return nil
+}
+
+func (o *ServiceOptions) compression() *CompressionOptions {
+	if o != nil {
+		return o.Compression
+	}
+	return nil
+}
+
+func (o *ServiceOptions) storeCompressed() bool { return o != nil && o.StoreCompressed }
+
+func (o *ServiceOptions) maxValueSize() int64 {
+	if o == nil || o.MaxValueSize <= 0 {
+		return defaultMaxValueSize
+	}
+	return o.MaxValueSize
+}
+
 func (s *Service) method(m string) string { return s.pfx + m }
 
 // Register adds method handlers to p for each of the applicable methods of s.
+// Each handler first checks the Authorize hook of the [ServiceOptions], if any.
+// Once [Service.Shutdown] has been called, the handlers reject all calls.
 func (s *Service) Register(p *chirp.Peer) {
-	p.Handle(s.method(mStatus), s.Status)
-	p.Handle(s.method(mGet), s.Get)
-	p.Handle(s.method(mHas), s.Has)
-	p.Handle(s.method(mPut), s.Put)
-	p.Handle(s.method(mDelete), s.Delete)
-	p.Handle(s.method(mList), s.List)
-	p.Handle(s.method(mLen), s.Len)
-	p.Handle(s.method(mKV), s.KV)
-	p.Handle(s.method(mCAS), s.KV) // alias for "kv", the server treats them the same
-	p.Handle(s.method(mSub), s.Sub)
+	handle := func(m string, h chirp.Handler) { p.Handle(s.method(m), s.gate(m, h)) }
+	handle(mStatus, s.Status)
+	handle(mCodecs, s.Codecs)
+	handle(mChecksums, s.Checksums)
+	handle(mGet, s.Get)
+	handle(mHas, s.Has)
+	handle(mPut, s.Put)
+	handle(mDelete, s.Delete)
+	handle(mList, s.List)
+	handle(mLen, s.Len)
+	handle(mDrop, s.Drop)
+	handle(mCopy, s.Copy)
+	handle(mMove, s.Move)
+	handle(mDigest, s.Digest)
+	handle(mMGet, s.MultiGet)
+	handle(mZGet, s.ZGet)
+	handle(mCGet, s.CheckedGet)
+	handle(mKV, s.KV)
+	handle(mCAS, s.KV) // alias for "kv", the server treats them the same
+	handle(mSub, s.Sub)
+	handle(mKeyspaces, s.Keyspaces)
+	handle(mSubstores, s.Substores)
+}
+
+// gate wraps the handler h for method m to check authorization, to reject
+// calls once the service begins to drain, and to track the handlers in flight
+// so that [Service.Shutdown] can wait for them.
+func (s *Service) gate(m string, h chirp.Handler) chirp.Handler {
+	return func(ctx context.Context, req *chirp.Request) ([]byte, error) {
+		if s.auth != nil {
+			if err := s.auth(ctx, m); err != nil {
+				return nil, err
+			}
+		}
+		s.μ.Lock()
+		if s.draining {
+			s.μ.Unlock()
+			return nil, &chirp.ErrorData{Code: codeUnavailable, Message: "service is shutting down"}
+		}
+		s.active.Add(1)
+		s.μ.Unlock()
+		defer s.active.Done()
+		return h(ctx, req)
+	}
 }
 
 // KV implements the eponymous method of the [blob.Store] interface.
@@ -112,6 +260,7 @@ func (s *Service) KV(ctx context.Context, req *chirp.Request) ([]byte, error) {
 		s.lastID++
 		kvID = s.lastID
 		s.kvs[kvID] = kv
+		s.kvNames[kvID] = name
 		si.kvs[name] = kvID
 	}
 	return KeyspaceResponse{ID: kvID}.Encode(), nil
@@ -147,13 +296,75 @@ func (s *Service) Sub(ctx context.Context, req *chirp.Request) ([]byte, error) {
 	return SubResponse{ID: subID}.Encode(), nil
 }
 
-// Status returns a JSON blob of server metrics.
+// A NameLister is an optional interface that a [blob.Store] may implement to
+// enumerate the names of the keyspaces and substores it contains.
+//
+// When a store managed by a [Service] implements this interface, the names it
+// reports are merged with the names opened by clients of the service.
+type NameLister interface {
+	// KVNames reports the names of the keyspaces defined in the store.
+	KVNames(ctx context.Context) ([]string, error)
+
+	// SubNames reports the names of the substores defined in the store.
+	SubNames(ctx context.Context) ([]string, error)
+}
+
+// Keyspaces reports the names of the keyspaces known in a store.  The names
+// include all the keyspaces opened by clients of the service, together with
+// any reported by the store if it implements [NameLister].
+func (s *Service) Keyspaces(ctx context.Context, req *chirp.Request) ([]byte, error) {
+	return s.listNames(ctx, req, func(si *storeInfo) map[string]int { return si.kvs }, NameLister.KVNames)
+}
+
+// Substores reports the names of the substores known in a store.  The names
+// include all the substores opened by clients of the service, together with
+// any reported by the store if it implements [NameLister].
+func (s *Service) Substores(ctx context.Context, req *chirp.Request) ([]byte, error) {
+	return s.listNames(ctx, req, func(si *storeInfo) map[string]int { return si.subs }, NameLister.SubNames)
+}
+
+func (s *Service) listNames(
+	ctx context.Context, req *chirp.Request,
+	open func(*storeInfo) map[string]int,
+	stored func(NameLister, context.Context) ([]string, error),
+) ([]byte, error) {
+	var nreq NamesRequest
+	if err := nreq.Decode(req.Data); err != nil {
+		return nil, err
+	}
+
+	// Capture the names already opened while holding the lock, but release it
+	// before consulting the store, which may be slow.
+	s.μ.Lock()
+	si := s.subs[nreq.ID]
+	if si == nil {
+		s.μ.Unlock()
+		return nil, fmt.Errorf("invalid store ID %d", nreq.ID)
+	}
+	names := slices.Collect(maps.Keys(open(si)))
+	s.μ.Unlock()
+
+	if nl, ok := si.store.(NameLister); ok {
+		more, err := stored(nl, ctx)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, more...)
+	}
+	slices.Sort(names)
+	return NamesResponse{Names: slices.Compact(names)}.Encode(), nil
+}
+
+// Status returns a JSON blob of server metrics.  The result includes the
+// metrics of the peer, along with an additional "store" entry reporting
+// metrics for the service.
 func (s *Service) Status(ctx context.Context, req *chirp.Request) ([]byte, error) {
-	// TODO(creachadair): Add some metrics about substore and keyspace usage.
-	mx := chirp.ContextPeer(ctx).Metrics()
 	if len(req.Data) != 0 {
 		return nil, errors.New("no parameters accepted")
 	}
+	mx := new(expvar.Map)
+	chirp.ContextPeer(ctx).Metrics().Do(func(kv expvar.KeyValue) { mx.Set(kv.Key, kv.Value) })
+	mx.Set("store", s.mx)
 	return []byte(mx.String()), nil
 }
 
@@ -167,7 +378,7 @@ func (s *Service) Get(ctx context.Context, req *chirp.Request) ([]byte, error) {
 	if kv == nil {
 		return invalidKeyspaceID(greq.ID)
 	}
-	data, err := kv.Get(ctx, string(greq.Key))
+	data, err := s.getValue(ctx, kv, string(greq.Key))
 	return data, filterErr(err)
 }
 
@@ -199,7 +410,35 @@ func (s *Service) Has(ctx context.Context, req *chirp.Request) ([]byte, error) {
 	return srsp, nil
 }
 
-// Put handles the corresponding method of [blob.KV].
+// MultiGet fetches the values of multiple keys in a single call. The response
+// reports the value of each key in order, or that the key was not found.
+// Errors other than a missing key cause the whole call to fail.
+func (s *Service) MultiGet(ctx context.Context, req *chirp.Request) ([]byte, error) {
+	var mreq MultiGetRequest
+	if err := mreq.Decode(req.Data); err != nil {
+		return nil, err
+	}
+	kv := s.idToKV(mreq.ID)
+	if kv == nil {
+		return invalidKeyspaceID(mreq.ID)
+	}
+	rsp := MultiGetResponse{Values: make([][]byte, len(mreq.Keys))}
+	for i, key := range mreq.Keys {
+		data, err := s.getValue(ctx, kv, key)
+		if blob.IsKeyNotFound(err) {
+			continue
+		} else if err != nil {
+			return nil, filterErr(err)
+		} else if data == nil {
+			data = []byte{}
+		}
+		rsp.Values[i] = data
+	}
+	return rsp.Encode(), nil
+}
+
+// Put handles the corresponding method of [blob.KV]. If the request carries a
+// checksum, the value is stored only if it matches.
 func (s *Service) Put(ctx context.Context, req *chirp.Request) ([]byte, error) {
 	var preq PutRequest
 	if err := preq.Decode(req.Data); err != nil {
@@ -209,11 +448,22 @@ func (s *Service) Put(ctx context.Context, req *chirp.Request) ([]byte, error) {
 	if kv == nil {
 		return invalidKeyspaceID(preq.ID)
 	}
-	return nil, filterErr(kv.Put(ctx, blob.PutOptions{
+	if preq.Checksum != ChecksumNone {
+		plain, err := decodeValue(preq.Codec, preq.Data, s.maxValue)
+		if err != nil {
+			return nil, err
+		} else if err := preq.Checksum.verify(string(preq.Key), preq.Sum, plain); err != nil {
+			return nil, filterErr(err)
+		}
+		if !s.zstore {
+			preq.Codec, preq.Data = CodecNone, plain // don't decompress it again
+		}
+	}
+	return nil, filterErr(s.storeValue(ctx, kv, blob.PutOptions{
 		Key:     string(preq.Key),
 		Data:    preq.Data,
 		Replace: preq.Replace,
-	}))
+	}, preq.Codec))
 }
 
 // Delete handles the corresponding method of [blob.KV].
@@ -276,6 +526,187 @@ func (s *Service) Len(ctx context.Context, req *chirp.Request) ([]byte, error) {
 	return packInt64(size), nil
 }
 
+// Drop removes all the keys from a keyspace, or all the keys sharing a given
+// prefix if one is specified. The response reports the number of keys removed.
+//
+// As a safeguard, the request must set Confirm and give the name of the
+// keyspace to be dropped; otherwise the request fails without effect.
+// While a drop is in progress, the "drop_keys_deleted" metric reported by
+// [Service.Status] is updated as each key is removed.
+func (s *Service) Drop(ctx context.Context, req *chirp.Request) ([]byte, error) {
+	var dreq DropRequest
+	if err := dreq.Decode(req.Data); err != nil {
+		return nil, err
+	}
+	kv, name := s.idToKVName(dreq.ID)
+	if kv == nil {
+		return invalidKeyspaceID(dreq.ID)
+	} else if !dreq.Confirm || string(dreq.Keyspace) != name {
+		return nil, fmt.Errorf("drop keyspace %d: confirmation does not match", dreq.ID)
+	}
+	s.dropsActive.Add(1)
+	defer s.dropsActive.Add(-1)
+
+	// A store is not required to permit modification while listing, so gather
+	// keys in batches and delete each batch after listing it.
+	pfx := string(dreq.Prefix)
+	var nd int64
+	for start := pfx; ; {
+		var batch []string
+		for key, err := range kv.List(ctx, start) {
+			if err != nil {
+				return nil, err
+			} else if !strings.HasPrefix(key, pfx) || len(batch) == dropBatchSize {
+				break
+			}
+			batch = append(batch, key)
+		}
+		if len(batch) == 0 {
+			break
+		}
+		for _, key := range batch {
+			if err := kv.Delete(ctx, key); blob.IsKeyNotFound(err) {
+				continue // deleted concurrently; not counted
+			} else if err != nil {
+				return nil, filterErr(err)
+			}
+			nd++
+			s.dropKeys.Add(1)
+		}
+		start = batch[len(batch)-1] + "\x00" // the next possible key
+	}
+	return packInt64(nd), nil
+}
+
+// Copy copies the values of the specified keys from one keyspace to another
+// without transferring the data to the client. The response reports the
+// number of keys copied. Copying stops at the first key that fails. The data
+// of the error are the failing key, prefixed by its length as a varint,
+// followed by the number of keys copied before it, encoded as for the
+// response.
+func (s *Service) Copy(ctx context.Context, req *chirp.Request) ([]byte, error) {
+	return s.transfer(ctx, req, false)
+}
+
+// Move behaves as [Service.Copy], but also deletes each key from the source
+// keyspace once it has been copied to the target.
+func (s *Service) Move(ctx context.Context, req *chirp.Request) ([]byte, error) {
+	return s.transfer(ctx, req, true)
+}
+
+func (s *Service) transfer(ctx context.Context, req *chirp.Request, move bool) ([]byte, error) {
+	var creq CopyRequest
+	if err := creq.Decode(req.Data); err != nil {
+		return nil, err
+	}
+	src := s.idToKV(creq.Src)
+	if src == nil {
+		return invalidKeyspaceID(creq.Src)
+	}
+	dst := s.idToKV(creq.Dst)
+	if dst == nil {
+		return invalidKeyspaceID(creq.Dst)
+	}
+	if creq.Src == creq.Dst {
+		return nil, errors.New("source and target keyspaces must differ")
+	}
+
+	var nc int64
+	for _, key := range creq.Keys {
+		if err := transferKey(ctx, src, dst, key, creq.Replace, move); err != nil {
+			return nil, transferErr(err, nc)
+		}
+		nc++
+	}
+	return packInt64(nc), nil
+}
+
+// transferKey copies the value of key from src to dst, and if move is true,
+// deletes it from src.
+func transferKey(ctx context.Context, src, dst blob.KV, key string, replace, move bool) error {
+	data, err := src.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	if err := dst.Put(ctx, blob.PutOptions{Key: key, Data: data, Replace: replace}); err != nil {
+		return err
+	}
+	if move {
+		if err := src.Delete(ctx, key); err != nil && !blob.IsKeyNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// transferErr converts err, reported by a copy or move after n keys were
+// transferred, into a service error. The data of the error are:
+//
+//	[Vn] keylen [n] key [rest] count
+//
+// where key is the key reported by [filterErr], if any, and count is n.
+func transferErr(err error, n int64) error {
+	ed, ok := filterErr(err).(*chirp.ErrorData)
+	if !ok {
+		ed = &chirp.ErrorData{Message: err.Error()}
+	}
+	var b packet.Builder
+	b.VPut(ed.Data)
+	b.Put(packInt64(n)...)
+	ed.Data = b.Bytes()
+	return ed
+}
+
+// Digest reports digests of the keys in a range of a keyspace. Comparing these
+// digests with those reported by another service allows the caller to locate
+// the keys that differ between them (see [Diff]). A digest covers only the keys
+// in its range, not their values.
+//
+// If the request has Split > 1, the range is partitioned into at most that many
+// contiguous subranges having approximately equal numbers of keys, and one
+// digest is reported for each. Otherwise, one digest is reported for the whole
+// range. Either way, the service lists the keys of the range once.
+func (s *Service) Digest(ctx context.Context, req *chirp.Request) ([]byte, error) {
+	var dreq DigestRequest
+	if err := dreq.Decode(req.Data); err != nil {
+		return nil, err
+	}
+	kv := s.idToKV(dreq.ID)
+	if kv == nil {
+		return invalidKeyspaceID(dreq.ID)
+	}
+	split := min(max(dreq.Split, 1), maxDigestSplit)
+	start, end := string(dreq.Start), string(dreq.End)
+
+	d := newSplitDigest(start, split)
+	for key, err := range listRange(ctx, kv, start, end) {
+		if err != nil {
+			return nil, err
+		}
+		d.add(key)
+	}
+	return DigestResponse{Ranges: d.digestRanges()}.Encode(), nil
+}
+
+// maxDigestSplit is the maximum number of subranges reported by Digest.
+const maxDigestSplit = 256
+
+// dropBatchSize is the maximum number of keys listed by Drop before deleting.
+const dropBatchSize = 1024
+
+func (s *Service) idToKVName(id int) (blob.KV, string) {
+	s.μ.Lock()
+	defer s.μ.Unlock()
+	return s.kvs[id], s.kvNames[id]
+}
+
+// numOpen reports the number of keyspaces and substores opened by clients.
+func (s *Service) numOpen() (kvs, subs int) {
+	s.μ.Lock()
+	defer s.μ.Unlock()
+	return len(s.kvs), len(s.subs) - 1 // don't count the root
+}
+
 func (s *Service) idToKV(id int) blob.KV {
 	s.μ.Lock()
 	defer s.μ.Unlock()
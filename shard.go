@@ -0,0 +1,592 @@
+package chirpstore
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"iter"
+	"slices"
+	"strconv"
+	"sync"
+
+	"github.com/creachadair/ffs/blob"
+)
+
+// ringReplicas is the number of points each shard occupies on the hash ring.
+const ringReplicas = 128
+
+// A Shard is a named member of a [ShardedStore]. The name identifies the
+// shard on the hash ring, so it must be the same each time the sharded store
+// is constructed; otherwise keys will be assigned to the wrong shards.
+type Shard struct {
+	Name  string
+	Store blob.Store // typically a [Store] connected to a service
+}
+
+// ShardedStore implements the [blob.StoreCloser] interface by distributing
+// keys among several stores using consistent hashing. Each keyspace and
+// substore of a ShardedStore is the union of the keyspaces and substores of
+// the same name in each shard.
+//
+// Shards can be added with [ShardedStore.AddShard]. Adding a shard reassigns
+// some of the existing keys to it; use [ShardedKV.Rebalance] to move the
+// values of those keys to their new shard.
+//
+// The state of a migration is kept only in memory. If a ShardedStore is
+// constructed with a new shard, or the program restarts after AddShard, before
+// each keyspace has been rebalanced, the keys not yet moved cannot be found
+// until Rebalance is called on a store constructed without the new shard.
+type ShardedStore struct {
+	set  *shardSet
+	path []string // substore names from the root
+}
+
+// NewShardedStore constructs a [ShardedStore] over the given shards, which
+// must have distinct non-empty names.
+func NewShardedStore(shards ...Shard) (ShardedStore, error) {
+	if len(shards) == 0 {
+		return ShardedStore{}, errors.New("no shards provided")
+	}
+	set := &shardSet{stores: make(map[string]blob.Store), balanced: make(map[string]int)}
+	for _, sh := range shards {
+		if err := set.checkNew(sh); err != nil {
+			return ShardedStore{}, err
+		}
+		set.stores[sh.Name] = sh.Store
+	}
+	set.rings = []*hashRing{newHashRing(set.names())}
+	return ShardedStore{set: set}, nil
+}
+
+// AddShard adds a new shard to s and all the stores sharing its shards. Some
+// keys that were assigned to existing shards are reassigned to the new shard.
+//
+// Until the values of reassigned keys in a keyspace are moved by
+// [ShardedKV.Rebalance], Get, Has, Put, and Delete on that keyspace also look
+// for them in the shards they were assigned to previously, at the cost of
+// extra calls. Once Rebalance completes, the keyspace no longer does so.
+func (s ShardedStore) AddShard(sh Shard) error {
+	s.set.μ.Lock()
+	defer s.set.μ.Unlock()
+	if err := s.set.checkNew(sh); err != nil {
+		return err
+	}
+	s.set.stores[sh.Name] = sh.Store
+	s.set.rings = append(s.set.rings, newHashRing(s.set.names()))
+	return nil
+}
+
+// KV implements a method of [blob.Store]. A successful result has concrete
+// type [*ShardedKV].
+func (s ShardedStore) KV(ctx context.Context, name string) (blob.KV, error) {
+	kv := &ShardedKV{
+		set:  s.set,
+		path: s.path,
+		name: name,
+		id:   keyspaceID(s.path, name),
+		kvs:  make(map[string]blob.KV),
+	}
+
+	// Open the keyspace on each shard to report errors promptly.
+	for _, shard := range s.set.shardNames() {
+		if _, err := kv.shardKV(ctx, shard); err != nil {
+			return nil, err
+		}
+	}
+	return kv, nil
+}
+
+// CAS implements a method of [blob.Store].
+func (s ShardedStore) CAS(ctx context.Context, name string) (blob.CAS, error) {
+	return blob.CASFromKVError(s.KV(ctx, name))
+}
+
+// Sub implements a method of [blob.Store]. A successful result has concrete
+// type [ShardedStore], sharing the shards of s.
+func (s ShardedStore) Sub(ctx context.Context, name string) (blob.Store, error) {
+	sub := ShardedStore{set: s.set, path: append(slices.Clip(s.path), name)}
+	for _, shard := range s.set.shardNames() {
+		if _, err := sub.shardStore(ctx, shard); err != nil {
+			return nil, err
+		}
+	}
+	return sub, nil
+}
+
+// Close implements part of the [blob.StoreCloser] interface. It closes each
+// shard that implements [blob.StoreCloser].
+func (s ShardedStore) Close(ctx context.Context) error {
+	s.set.μ.RLock()
+	defer s.set.μ.RUnlock()
+	var errs []error
+	for name, st := range s.set.stores {
+		if sc, ok := st.(blob.StoreCloser); ok {
+			if err := sc.Close(ctx); err != nil {
+				errs = append(errs, fmt.Errorf("close shard %q: %w", name, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// shardStore returns the substore of the named shard corresponding to s.
+func (s ShardedStore) shardStore(ctx context.Context, shard string) (blob.Store, error) {
+	st := s.set.store(shard)
+	for _, name := range s.path {
+		var err error
+		st, err = st.Sub(ctx, name)
+		if err != nil {
+			return nil, fmt.Errorf("shard %q: open substore %q: %w", shard, name, err)
+		}
+	}
+	return st, nil
+}
+
+// ShardedKV implements the [blob.KV] interface for a keyspace of a
+// [ShardedStore]. Each key is stored in the shard to which it is assigned by
+// consistent hashing.
+//
+// List reports the keys of all shards merged in order, and Len reports the
+// total number of keys in all shards. While a keyspace is being rebalanced, a
+// key may briefly be stored in two shards; List reports it only once, but Len
+// counts both copies.
+type ShardedKV struct {
+	set  *shardSet
+	path []string
+	name string
+	id   string // identifies the keyspace in set (see keyspaceID)
+
+	μ   sync.Mutex
+	kvs map[string]blob.KV // shard name to keyspace
+}
+
+// Get implements a method of [blob.KV].
+func (s *ShardedKV) Get(ctx context.Context, key string) ([]byte, error) {
+	owner, prev := s.set.owners(s.id, key)
+	kv, err := s.shardKV(ctx, owner)
+	if err != nil {
+		return nil, err
+	}
+	data, err := kv.Get(ctx, key)
+	if blob.IsKeyNotFound(err) {
+		for _, shard := range prev {
+			if kv, err := s.shardKV(ctx, shard); err != nil {
+				return nil, err
+			} else if data, err := kv.Get(ctx, key); err == nil {
+				return data, nil
+			} else if !blob.IsKeyNotFound(err) {
+				return nil, err
+			}
+		}
+	}
+	return data, err
+}
+
+// Has implements a method of [blob.KV].
+func (s *ShardedKV) Has(ctx context.Context, keys ...string) (blob.KeySet, error) {
+	// Group the keys by shard, check each shard, then check the previous
+	// owners of any keys not found.
+	var out blob.KeySet
+	byShard := make(map[string][]string)
+	for _, key := range keys {
+		owner, _ := s.set.owners(s.id, key)
+		byShard[owner] = append(byShard[owner], key)
+	}
+	missing := make(map[string][]string)
+	for shard, skeys := range byShard {
+		found, err := s.shardHas(ctx, shard, skeys)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range skeys {
+			if found.Has(key) {
+				out.Add(key)
+				continue
+			}
+			_, prev := s.set.owners(s.id, key)
+			for _, p := range prev {
+				missing[p] = append(missing[p], key)
+			}
+		}
+	}
+	for shard, skeys := range missing {
+		found, err := s.shardHas(ctx, shard, skeys)
+		if err != nil {
+			return nil, err
+		}
+		out.AddAll(found)
+	}
+	return out, nil
+}
+
+func (s *ShardedKV) shardHas(ctx context.Context, shard string, keys []string) (blob.KeySet, error) {
+	kv, err := s.shardKV(ctx, shard)
+	if err != nil {
+		return nil, err
+	}
+	return kv.Has(ctx, keys...)
+}
+
+// Put implements a method of [blob.KV].
+func (s *ShardedKV) Put(ctx context.Context, opts blob.PutOptions) error {
+	owner, prev := s.set.owners(s.id, opts.Key)
+	if !opts.Replace {
+		// The key may exist in a previous shard, if it has not been moved.
+		for _, shard := range prev {
+			found, err := s.shardHas(ctx, shard, []string{opts.Key})
+			if err != nil {
+				return err
+			} else if found.Has(opts.Key) {
+				return blob.KeyExists(opts.Key)
+			}
+		}
+	}
+	kv, err := s.shardKV(ctx, owner)
+	if err != nil {
+		return err
+	}
+	return kv.Put(ctx, opts)
+}
+
+// Delete implements a method of [blob.KV].
+func (s *ShardedKV) Delete(ctx context.Context, key string) error {
+	owner, prev := s.set.owners(s.id, key)
+	kv, err := s.shardKV(ctx, owner)
+	if err != nil {
+		return err
+	}
+	err = kv.Delete(ctx, key)
+	for _, shard := range prev {
+		pkv, perr := s.shardKV(ctx, shard)
+		if perr != nil {
+			return perr
+		} else if perr := pkv.Delete(ctx, key); perr == nil && blob.IsKeyNotFound(err) {
+			err = nil // the key had not yet been moved
+		} else if perr != nil && !blob.IsKeyNotFound(perr) {
+			return perr
+		}
+	}
+	return err
+}
+
+// List implements a method of [blob.KV]. The keys of all the shards are
+// merged in lexicographic order.
+func (s *ShardedKV) List(ctx context.Context, start string) iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		var seqs []iter.Seq2[string, error]
+		for _, shard := range s.set.shardNames() {
+			kv, err := s.shardKV(ctx, shard)
+			if err != nil {
+				yield("", err)
+				return
+			}
+			seqs = append(seqs, kv.List(ctx, start))
+		}
+		for key, err := range mergeLists(seqs) {
+			if !yield(key, err) || err != nil {
+				return
+			}
+		}
+	}
+}
+
+// Len implements a method of [blob.KV]. It reports the total number of keys
+// in all the shards.
+func (s *ShardedKV) Len(ctx context.Context) (int64, error) {
+	var total int64
+	for _, shard := range s.set.shardNames() {
+		kv, err := s.shardKV(ctx, shard)
+		if err != nil {
+			return 0, err
+		}
+		n, err := kv.Len(ctx)
+		if err != nil {
+			return 0, err
+		}
+		total += n
+	}
+	return total, nil
+}
+
+// RebalanceStats report the results of a call to [ShardedKV.Rebalance].
+type RebalanceStats struct {
+	Checked int64 // keys checked in all shards, including moved keys
+	Moved   int64 // keys moved to a different shard
+}
+
+// Rebalance moves the values of keys in s that are not stored in the shard
+// to which they are assigned, as happens when a shard is added. Each such key
+// is copied to its assigned shard, if it is not already present there, and
+// then deleted from the shard where it was found.
+//
+// Once Rebalance completes successfully, operations on s no longer look for
+// keys in the shards to which they were assigned before shards were added.
+func (s *ShardedKV) Rebalance(ctx context.Context) (RebalanceStats, error) {
+	var stats RebalanceStats
+	gen := s.set.generation()
+	for _, shard := range s.set.shardNames() {
+		src, err := s.shardKV(ctx, shard)
+		if err != nil {
+			return stats, err
+		}
+		for batch, err := range listBatches(ctx, src, 256) {
+			if err != nil {
+				return stats, err
+			}
+			stats.Checked += int64(len(batch))
+			for _, key := range batch {
+				owner, _ := s.set.owners(s.id, key)
+				if owner == shard {
+					continue
+				}
+				dst, err := s.shardKV(ctx, owner)
+				if err != nil {
+					return stats, err
+				}
+				if err := moveKey(ctx, src, dst, key); err != nil {
+					return stats, fmt.Errorf("move %q from shard %q to %q: %w", key, shard, owner, err)
+				}
+				stats.Moved++
+			}
+		}
+	}
+	s.set.setBalanced(s.id, gen)
+	return stats, nil
+}
+
+// moveKey copies the value of key from src to dst, unless it is already
+// present in dst, then deletes it from src.
+func moveKey(ctx context.Context, src, dst blob.KV, key string) error {
+	data, err := src.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	if err := dst.Put(ctx, blob.PutOptions{Key: key, Data: data}); err != nil && !blob.IsKeyExists(err) {
+		return err
+	}
+	return src.Delete(ctx, key)
+}
+
+// shardKV returns the keyspace of the named shard corresponding to s.
+func (s *ShardedKV) shardKV(ctx context.Context, shard string) (blob.KV, error) {
+	s.μ.Lock()
+	defer s.μ.Unlock()
+	if kv, ok := s.kvs[shard]; ok {
+		return kv, nil
+	}
+	st, err := ShardedStore{set: s.set, path: s.path}.shardStore(ctx, shard)
+	if err != nil {
+		return nil, err
+	}
+	kv, err := st.KV(ctx, s.name)
+	if err != nil {
+		return nil, fmt.Errorf("shard %q: open keyspace %q: %w", shard, s.name, err)
+	}
+	s.kvs[shard] = kv
+	return kv, nil
+}
+
+// shardSet is the set of shards shared by the stores of a [ShardedStore].
+type shardSet struct {
+	μ      sync.RWMutex
+	stores map[string]blob.Store
+
+	// Each assignment of keys to shards, oldest first. The last is current,
+	// and each AddShard adds another.
+	rings []*hashRing
+
+	// For each keyspace that has been rebalanced, the index in rings of the
+	// assignment to which it was last rebalanced. Assignments before that one
+	// need not be consulted for that keyspace.
+	balanced map[string]int
+}
+
+func (s *shardSet) checkNew(sh Shard) error {
+	if sh.Name == "" {
+		return errors.New("empty shard name")
+	} else if sh.Store == nil {
+		return fmt.Errorf("shard %q has no store", sh.Name)
+	} else if _, ok := s.stores[sh.Name]; ok {
+		return fmt.Errorf("duplicate shard name %q", sh.Name)
+	}
+	return nil
+}
+
+// names returns the shard names in order. The caller must hold s.μ.
+func (s *shardSet) names() []string {
+	names := make([]string, 0, len(s.stores))
+	for name := range s.stores {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func (s *shardSet) shardNames() []string {
+	s.μ.RLock()
+	defer s.μ.RUnlock()
+	return s.names()
+}
+
+func (s *shardSet) store(name string) blob.Store {
+	s.μ.RLock()
+	defer s.μ.RUnlock()
+	return s.stores[name]
+}
+
+// owners reports the shard to which key is assigned, and the distinct shards
+// other than that to which it was assigned since the keyspace with the given
+// ID was last rebalanced.
+func (s *shardSet) owners(id, key string) (owner string, prev []string) {
+	s.μ.RLock()
+	defer s.μ.RUnlock()
+	h := ringHash(key)
+	cur := len(s.rings) - 1
+	owner = s.rings[cur].owner(h)
+	for _, r := range s.rings[s.balanced[id]:cur] {
+		if p := r.owner(h); p != owner && !slices.Contains(prev, p) {
+			prev = append(prev, p)
+		}
+	}
+	return owner, prev
+}
+
+// generation reports the index of the current assignment of keys.
+func (s *shardSet) generation() int {
+	s.μ.RLock()
+	defer s.μ.RUnlock()
+	return len(s.rings) - 1
+}
+
+// setBalanced records that the keyspace with the given ID has been rebalanced
+// to the assignment with index gen.
+func (s *shardSet) setBalanced(id string, gen int) {
+	s.μ.Lock()
+	defer s.μ.Unlock()
+	s.balanced[id] = max(s.balanced[id], gen)
+}
+
+// keyspaceID returns a string identifying the keyspace with the given name in
+// the substore with the given path.
+func keyspaceID(path []string, name string) string {
+	var buf []byte
+	for _, p := range append(slices.Clip(path), name) {
+		buf = binary.AppendUvarint(buf, uint64(len(p)))
+		buf = append(buf, p...)
+	}
+	return string(buf)
+}
+
+// A hashRing assigns keys to shards by consistent hashing.
+type hashRing struct {
+	points []ringPoint // ordered by hash
+}
+
+type ringPoint struct {
+	hash  uint64
+	shard string
+}
+
+func newHashRing(shards []string) *hashRing {
+	r := &hashRing{points: make([]ringPoint, 0, len(shards)*ringReplicas)}
+	for _, shard := range shards {
+		for i := range ringReplicas {
+			r.points = append(r.points, ringPoint{
+				hash:  ringHash(shard + "#" + strconv.Itoa(i)),
+				shard: shard,
+			})
+		}
+	}
+	slices.SortFunc(r.points, func(a, b ringPoint) int {
+		if a.hash < b.hash {
+			return -1
+		} else if a.hash > b.hash {
+			return 1
+		}
+		return 0
+	})
+	return r
+}
+
+// owner returns the shard owning the first point at or after h on r.
+func (r *hashRing) owner(h uint64) string {
+	i, _ := slices.BinarySearchFunc(r.points, h, func(p ringPoint, h uint64) int {
+		if p.hash < h {
+			return -1
+		} else if p.hash > h {
+			return 1
+		}
+		return 0
+	})
+	if i == len(r.points) {
+		i = 0 // wrap around
+	}
+	return r.points[i].shard
+}
+
+func ringHash(s string) uint64 {
+	sum := sha256.Sum256([]byte(s))
+	return binary.BigEndian.Uint64(sum[:8])
+}
+
+// mergeLists merges ordered sequences of keys into a single ordered sequence
+// without duplicates. It stops at the first error.
+func mergeLists(seqs []iter.Seq2[string, error]) iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		type head struct {
+			key  string
+			next func() (string, error, bool)
+		}
+		var heads []*head
+		var stops []func()
+		defer func() {
+			for _, stop := range stops {
+				stop()
+			}
+		}()
+		advance := func(h *head) (ok bool, err error) {
+			key, err, ok := h.next()
+			if ok && err == nil {
+				h.key = key
+			}
+			return ok, err
+		}
+		for _, seq := range seqs {
+			next, stop := iter.Pull2(seq)
+			stops = append(stops, stop)
+			h := &head{next: next}
+			if ok, err := advance(h); err != nil {
+				yield("", err)
+				return
+			} else if ok {
+				heads = append(heads, h)
+			}
+		}
+		for len(heads) != 0 {
+			least := heads[0].key
+			for _, h := range heads[1:] {
+				least = min(least, h.key)
+			}
+			if !yield(least, nil) {
+				return
+			}
+			// Advance all the sequences positioned at the least key.
+			live := heads[:0]
+			for _, h := range heads {
+				if h.key != least {
+					live = append(live, h)
+					continue
+				}
+				if ok, err := advance(h); err != nil {
+					yield("", err)
+					return
+				} else if ok {
+					live = append(live, h)
+				}
+			}
+			heads = live
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package chirpstore_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/creachadair/chirpstore"
+	"github.com/creachadair/ffs/blob"
+	"github.com/creachadair/ffs/blob/storetest"
+)
+
+func newShard(t *testing.T, name string) chirpstore.Shard {
+	t.Helper()
+	return chirpstore.Shard{Name: name, Store: chirpstore.NewStore(newTestService(t, nil), nil)}
+}
+
+func TestShardedStore(t *testing.T) {
+	ss, err := chirpstore.NewShardedStore(newShard(t, "s1"), newShard(t, "s2"), newShard(t, "s3"))
+	if err != nil {
+		t.Fatalf("NewShardedStore: unexpected error: %v", err)
+	}
+	storetest.Run(t, ss)
+}
+
+func TestShardedRebalance(t *testing.T) {
+	ctx := t.Context()
+	s1, s2 := newShard(t, "s1"), newShard(t, "s2")
+	ss, err := chirpstore.NewShardedStore(s1, s2)
+	if err != nil {
+		t.Fatalf("NewShardedStore: unexpected error: %v", err)
+	}
+	kvi, err := ss.KV(ctx, "data")
+	if err != nil {
+		t.Fatalf("KV: unexpected error: %v", err)
+	}
+	kv := kvi.(*chirpstore.ShardedKV)
+
+	const numKeys = 200
+	keys := make([]string, numKeys)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key-%03d", i)
+		if err := kv.Put(ctx, blob.PutOptions{Key: keys[i], Data: []byte("v:" + keys[i])}); err != nil {
+			t.Fatalf("Put %q: unexpected error: %v", keys[i], err)
+		}
+	}
+	checkKeys := func() {
+		t.Helper()
+		for _, key := range keys {
+			if got, err := kv.Get(ctx, key); err != nil || string(got) != "v:"+key {
+				t.Errorf("Get %q: got (%q, %v), want v:%s", key, got, err, key)
+			}
+		}
+		ks, err := kv.Has(ctx, keys...)
+		if err != nil {
+			t.Fatalf("Has: unexpected error: %v", err)
+		} else if ks.Len() != numKeys {
+			t.Errorf("Has: got %d keys, want %d", ks.Len(), numKeys)
+		}
+		if got := listKeys(t, kv); len(got) != numKeys {
+			t.Errorf("List: got %d keys, want %d", len(got), numKeys)
+		}
+		if n, err := kv.Len(ctx); err != nil || n != numKeys {
+			t.Errorf("Len: got (%d, %v), want %d", n, err, numKeys)
+		}
+	}
+	checkKeys()
+
+	// Both shards should have received some of the keys.
+	for _, sh := range []chirpstore.Shard{s1, s2} {
+		if n, err := mustKV(t, sh.Store, "data").Len(ctx); err != nil || n == 0 {
+			t.Errorf("Shard %q Len: got (%d, %v), want > 0", sh.Name, n, err)
+		}
+	}
+
+	// After adding a shard, all the keys remain reachable.
+	s3 := newShard(t, "s3")
+	if err := ss.AddShard(s3); err != nil {
+		t.Fatalf("AddShard: unexpected error: %v", err)
+	}
+	if err := ss.AddShard(s3); err == nil {
+		t.Error("AddShard: duplicate shard was accepted")
+	}
+	checkKeys()
+
+	// Putting a key that has not been moved is reported as a conflict.
+	for _, key := range keys {
+		if err := kv.Put(ctx, blob.PutOptions{Key: key, Data: []byte("x")}); !blob.IsKeyExists(err) {
+			t.Errorf("Put %q: got %v, want key exists", key, err)
+		}
+	}
+
+	stats, err := kv.Rebalance(ctx)
+	if err != nil {
+		t.Fatalf("Rebalance: unexpected error: %v", err)
+	}
+	if stats.Checked < numKeys || stats.Moved == 0 || stats.Moved == numKeys {
+		t.Errorf("Rebalance: got %+v, want all keys checked and some moved", stats)
+	}
+	if n, err := mustKV(t, s3.Store, "data").Len(ctx); err != nil || n != stats.Moved {
+		t.Errorf("New shard Len: got (%d, %v), want %d", n, err, stats.Moved)
+	}
+	checkKeys()
+
+	// A second rebalance has nothing to move.
+	stats, err = kv.Rebalance(ctx)
+	if err != nil {
+		t.Fatalf("Rebalance: unexpected error: %v", err)
+	} else if stats.Moved != 0 {
+		t.Errorf("Rebalance: moved %d keys, want 0", stats.Moved)
+	}
+
+	// Shards can be added repeatedly before rebalancing.
+	s4, s5 := newShard(t, "s4"), newShard(t, "s5")
+	for _, sh := range []chirpstore.Shard{s4, s5} {
+		if err := ss.AddShard(sh); err != nil {
+			t.Fatalf("AddShard %q: unexpected error: %v", sh.Name, err)
+		}
+		checkKeys()
+	}
+	if _, err := kv.Rebalance(ctx); err != nil {
+		t.Fatalf("Rebalance: unexpected error: %v", err)
+	}
+	checkKeys()
+
+	// Once rebalanced, the keyspace no longer consults previous owners: a
+	// stray copy of a key in a shard that no longer owns it is not found.
+	moved := listKeys(t, mustKV(t, s5.Store, "data"))
+	if len(moved) == 0 {
+		t.Fatal("No keys were moved to the last shard")
+	}
+	if err := kv.Delete(ctx, moved[0]); err != nil {
+		t.Fatalf("Delete %q: unexpected error: %v", moved[0], err)
+	}
+	for _, sh := range []chirpstore.Shard{s1, s2, s3, s4} {
+		if err := mustKV(t, sh.Store, "data").Put(ctx, blob.PutOptions{Key: moved[0], Data: []byte("stray")}); err != nil {
+			t.Fatalf("Put stray copy: unexpected error: %v", err)
+		}
+	}
+	if got, err := kv.Get(ctx, moved[0]); !blob.IsKeyNotFound(err) {
+		t.Errorf("Get %q: got (%q, %v), want key not found", moved[0], got, err)
+	}
+}
+
+// downStore wraps a store so that its keyspace fails while down is set. It
+// supports only one keyspace.
+type downStore struct {
+	blob.Store
+	kv *downKV
+}
+
+func (d *downStore) KV(ctx context.Context, name string) (blob.KV, error) {
+	if d.kv == nil {
+		kv, err := d.Store.KV(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		d.kv = &downKV{KV: kv}
+	}
+	return d.kv, nil
+}
+
+func TestShardedPrevError(t *testing.T) {
+	ctx := t.Context()
+	s1 := newShard(t, "s1")
+	ds := &downStore{Store: s1.Store}
+	s1.Store = ds
+	ss, err := chirpstore.NewShardedStore(s1)
+	if err != nil {
+		t.Fatalf("NewShardedStore: unexpected error: %v", err)
+	}
+	kv, err := ss.KV(ctx, "data")
+	if err != nil {
+		t.Fatalf("KV: unexpected error: %v", err)
+	}
+	keys := make([]string, 50)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key-%03d", i)
+		if err := kv.Put(ctx, blob.PutOptions{Key: keys[i], Data: []byte("v")}); err != nil {
+			t.Fatalf("Put %q: unexpected error: %v", keys[i], err)
+		}
+	}
+	if err := ss.AddShard(newShard(t, "s2")); err != nil {
+		t.Fatalf("AddShard: unexpected error: %v", err)
+	}
+
+	// While the previous owner of the keys is failing, the keys that now
+	// belong to the new shard are not reported as missing.
+	ds.kv.down.Store(true)
+	defer ds.kv.down.Store(false)
+	for _, key := range keys {
+		if got, err := kv.Get(ctx, key); !errors.Is(err, errDown) {
+			t.Errorf("Get %q: got (%q, %v), want %v", key, got, err, errDown)
+		}
+	}
+}
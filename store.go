@@ -5,8 +5,10 @@ import (
 	"errors"
 	"fmt"
 	"iter"
+	"time"
 
 	"github.com/creachadair/chirp"
+	"github.com/creachadair/chirp/packet"
 	"github.com/creachadair/ffs/blob"
 	"github.com/creachadair/ffs/storage/dbkey"
 	"github.com/creachadair/ffs/storage/monitor"
@@ -20,34 +22,52 @@ type Store struct {
 
 // NewStore constructs a Store that delegates through the given peer.
 func NewStore(peer *chirp.Peer, opts *StoreOptions) Store {
+	return newStore([]*chirp.Peer{peer}, opts)
+}
+
+func newStore(peers []*chirp.Peer, opts *StoreOptions) Store {
 	if p := opts.packetLogger(); p != nil {
-		peer.LogPackets(p)
+		for _, peer := range peers {
+			peer.LogPackets(p)
+		}
 	}
 	return Store{M: monitor.New(monitor.Config[chirpStub, KV]{
-		DB: chirpStub{pfx: opts.methodPrefix(), id: 0, peer: peer},
+		DB: chirpStub{
+			pfx:      opts.methodPrefix(),
+			id:       0,
+			peer:     peers[0],
+			stripe:   newStripe(peers),
+			cache:    opts.newCache(),
+			cacheAll: opts.cacheAllKeyspaces(),
+			window:   opts.batchWindow(),
+			retry:    opts.retryPolicy(),
+			wire:     newWireCodec(opts.compression()),
+			sums:     newWireSum(opts.checksum()),
+		},
 		NewKV: func(ctx context.Context, db chirpStub, pfx dbkey.Prefix, name string) (KV, error) {
-			var rsp KeyspaceResponse
-			if krsp, err := db.peer.Call(ctx, db.method(mKV), KeyspaceRequest{
-				ID:  db.id,
-				Key: []byte(name),
-			}.Encode()); err != nil {
-				return KV{}, err
-			} else if rsp.Decode(krsp.Data); err != nil {
+			id, err := db.open(ctx, mKV, name)
+			if err != nil {
 				return KV{}, err
 			}
-			return KV{spaceID: rsp.ID, pfx: db.pfx, peer: db.peer}, nil
+			return KV{
+				spaceID:  id,
+				pfx:      db.pfx,
+				peer:     db.peer,
+				stripe:   db.stripe,
+				cache:    db.cache,
+				useCache: db.cacheAll,
+				batch:    newBatcher(db.window),
+				retry:    db.retry,
+				wire:     db.wire,
+				sums:     db.sums,
+			}, nil
 		},
 		NewSub: func(ctx context.Context, db chirpStub, pfx dbkey.Prefix, name string) (chirpStub, error) {
-			var rsp SubResponse
-			if srsp, err := db.peer.Call(ctx, db.method(mSub), SubRequest{
-				ID:  db.id,
-				Key: []byte(name),
-			}.Encode()); err != nil {
-				return db, err
-			} else if rsp.Decode(srsp.Data); err != nil {
+			id, err := db.open(ctx, mSub, name)
+			if err != nil {
 				return db, err
 			}
-			return db.withID(rsp.ID), nil
+			return db.withID(id), nil
 		},
 	})}
 }
@@ -60,6 +80,46 @@ type StoreOptions struct {
 
 	// A packet logger to attach to the peer used by the store.
 	PacketLogger chirp.PacketLogger
+
+	// If positive, enable a client-side LRU cache holding up to this many
+	// bytes of values returned by Get. The cache is shared by the store and
+	// all its substores. By default it applies only to keyspaces opened with
+	// CAS, whose values cannot change once written. Values written or deleted
+	// through the store are removed from the cache, but changes made by other
+	// clients of the service are not detected. See [Store.CacheStats].
+	CacheSize int64
+
+	// If true, and CacheSize is positive, the cache also applies to keyspaces
+	// opened with KV. This is safe only if no other client modifies the
+	// values in those keyspaces.
+	CacheAllKeyspaces bool
+
+	// If positive, concurrent calls to Has and Get on the same keyspace are
+	// collected for up to this long, and then sent to the service together in
+	// a single call. This reduces the number of round trips for workloads
+	// that issue many small concurrent requests, at the cost of adding up to
+	// this much latency to each call. Batching Get requires a service that
+	// supports the "mget" method, and is not done while checksums are in
+	// effect (see Checksum), since batched values do not carry them.
+	BatchWindow time.Duration
+
+	// If set, calls to the service that fail with a transient error are
+	// retried according to this policy. If nil, calls are not retried.
+	Retry *RetryPolicy
+
+	// If set, values sent by Put are compressed when that makes them smaller,
+	// and Get accepts compressed values from the service. Whether the service
+	// supports compression is checked on first use; if not, values are sent
+	// plain. Batched reads (see BatchWindow) are not compressed.
+	Compression *CompressionOptions
+
+	// If not ChecksumNone, values sent by Put and returned by Get carry a
+	// checksum of this kind, which the receiver verifies before accepting the
+	// value. A value that does not match is reported as a [*ChecksumError].
+	// Whether the service supports the checksum is checked on first use; if
+	// not, values are sent without checksums. While checksums are in effect,
+	// Get is not batched (see BatchWindow).
+	Checksum Checksum
 }
 
 func (o *StoreOptions) methodPrefix() string {
@@ -76,21 +136,145 @@ func (o *StoreOptions) packetLogger() chirp.PacketLogger {
 	return nil
 }
 
+func (o *StoreOptions) newCache() *readCache {
+	if o == nil || o.CacheSize <= 0 {
+		return nil
+	}
+	return newReadCache(o.CacheSize)
+}
+
+func (o *StoreOptions) cacheAllKeyspaces() bool { return o != nil && o.CacheAllKeyspaces }
+
+func (o *StoreOptions) retryPolicy() *RetryPolicy {
+	if o == nil {
+		return nil
+	}
+	return o.Retry
+}
+
+func (o *StoreOptions) compression() *CompressionOptions {
+	if o == nil {
+		return nil
+	}
+	return o.Compression
+}
+
+func (o *StoreOptions) checksum() Checksum {
+	if o == nil {
+		return ChecksumNone
+	}
+	return o.Checksum
+}
+
+func (o *StoreOptions) batchWindow() time.Duration {
+	if o == nil {
+		return 0
+	}
+	return o.BatchWindow
+}
+
 // chirpStub contains the metadata for a substore.
 type chirpStub struct {
-	pfx  string
-	id   int
-	peer *chirp.Peer
+	pfx    string
+	id     int
+	peer   *chirp.Peer
+	stripe *stripe // nil unless the store has multiple peers
+
+	cache    *readCache // nil if caching is disabled
+	cacheAll bool       // cache reads for keyspaces opened with KV
+
+	window time.Duration // batching window for Has and Get (0 to disable)
+	retry  *RetryPolicy  // nil to disable retries
+	wire   *wireCodec    // nil to disable compression
+	sums   *wireSum      // nil to disable checksums
 }
 
 func (s chirpStub) method(m string) string { return s.pfx + m }
 
 func (s chirpStub) withID(id int) chirpStub { s.id = id; return s }
 
-// Close implements part of the [blob.StoreCloser] interface.
-func (s Store) Close(context.Context) error { return s.DB.peer.Stop() }
+func (s chirpStub) listNames(ctx context.Context, m string) ([]string, error) {
+	rsp, err := s.peer.Call(ctx, s.method(m), NamesRequest{ID: s.id}.Encode())
+	if err != nil {
+		return nil, unfilterErr(err)
+	}
+	var nrsp NamesResponse
+	if err := nrsp.Decode(rsp.Data); err != nil {
+		return nil, err
+	}
+	return nrsp.Names, nil
+}
 
-// Peer returns a handle to the [chirp.Peer] used by s.
+// open opens the named keyspace (m == mKV) or substore (m == mSub) of s, and
+// returns its ID. In a striped store it is opened on each peer.
+func (s chirpStub) open(ctx context.Context, m, name string) (int, error) {
+	req := IDKeyRequest{ID: s.id, Key: []byte(name)}.Encode()
+	id := -1
+	for _, peer := range s.stripe.all(s.peer) {
+		rsp, err := peer.Call(ctx, s.method(m), req)
+		if err != nil {
+			return 0, unfilterErr(err)
+		}
+		var irsp IDOnly
+		if err := irsp.Decode(rsp.Data); err != nil {
+			return 0, err
+		} else if id >= 0 && irsp.ID != id {
+			return 0, fmt.Errorf("peers disagree on the ID of %q (%d, %d); are they connected to the same service?",
+				name, id, irsp.ID)
+		}
+		id = irsp.ID
+	}
+	return id, nil
+}
+
+// Close implements part of the [blob.StoreCloser] interface. It stops the
+// peer used by s, or in a striped store, all the peers.
+func (s Store) Close(context.Context) error {
+	var errs []error
+	for _, peer := range s.DB.stripe.all(s.DB.peer) {
+		errs = append(errs, peer.Stop())
+	}
+	return errors.Join(errs...)
+}
+
+// Sub implements a method of [blob.Store]. A successful result has concrete
+// type [Store], sharing the peer of s.
+func (s Store) Sub(ctx context.Context, name string) (blob.Store, error) {
+	sub, err := s.M.Sub(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return Store{M: sub.(*monitor.M[chirpStub, KV])}, nil
+}
+
+// CAS implements a method of [blob.Store]. If the read cache is enabled (see
+// [StoreOptions]), the Get method of the result consults the cache.
+func (s Store) CAS(ctx context.Context, name string) (blob.CAS, error) {
+	kv, err := s.M.KV(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	ckv := kv.(KV)
+	ckv.useCache = ckv.cache != nil
+	return blob.CASFromKV(ckv), nil
+}
+
+// KVNames reports the names of the keyspaces known to the service in the
+// store represented by s, in lexicographic order. It implements part of the
+// [NameLister] interface.
+func (s Store) KVNames(ctx context.Context) ([]string, error) {
+	return s.DB.listNames(ctx, mKeyspaces)
+}
+
+// SubNames reports the names of the substores known to the service in the
+// store represented by s, in lexicographic order. It implements part of the
+// [NameLister] interface.
+func (s Store) SubNames(ctx context.Context) ([]string, error) {
+	return s.DB.listNames(ctx, mSubstores)
+}
+
+// Peer returns a handle to the [chirp.Peer] used by s. In a striped store, it
+// returns the first of the peers.
 func (s Store) Peer() *chirp.Peer { return s.DB.peer }
 
 // KV implements the [blob.KV] interface by calling a Chirp v0 peer.
@@ -98,39 +282,127 @@ type KV struct {
 	spaceID int
 	pfx     string
 	peer    *chirp.Peer
+	stripe  *stripe // nil unless the store has multiple peers
+
+	cache    *readCache // if non-nil, invalidated by writes
+	useCache bool       // if true, Get consults the cache
+
+	batch *batcher     // if non-nil, batch Has and Get calls
+	retry *RetryPolicy // if non-nil, retry idempotent calls
+	wire  *wireCodec   // if non-nil, compress values when supported
+	sums  *wireSum     // if non-nil, check values when supported
 }
 
 func (s KV) method(m string) string { return s.pfx + m }
 
 // Get implements a method of [blob.KV].
 func (s KV) Get(ctx context.Context, key string) ([]byte, error) {
-	rsp, err := s.peer.Call(ctx, s.method(mGet), GetRequest{
+	var gen uint64
+	if s.useCache {
+		if data, ok := s.cache.get(s.spaceID, key); ok {
+			return data, nil
+		}
+		gen = s.cache.generation()
+	}
+	data, err := s.callGet(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if s.useCache {
+		// Don't cache the value if a write may have raced with the fetch.
+		s.cache.putIf(gen, s.spaceID, key, data)
+	}
+	return data, nil
+}
+
+func (s KV) callGet(ctx context.Context, key string) ([]byte, error) {
+	if s.batch != nil && !s.sums.ready(ctx, s) {
+		data, err := s.batchGet(ctx, key)
+		if err != nil {
+			return nil, err
+		} else if data == nil {
+			return nil, blob.KeyNotFound(key)
+		}
+		return data, nil
+	}
+	if s.sums.ready(ctx, s) {
+		return s.callCheckedGet(ctx, key)
+	} else if s.wire.ready(ctx, s) {
+		return s.callZGet(ctx, key)
+	}
+	rsp, err := s.call(ctx, mGet, GetRequest{
 		ID:  s.spaceID,
 		Key: []byte(key),
-	}.Encode())
+	}.Encode(), true)
 	if err != nil {
 		return nil, unfilterErr(err)
 	}
 	return rsp.Data, nil
 }
 
+func (s KV) callZGet(ctx context.Context, key string) ([]byte, error) {
+	rsp, err := s.call(ctx, mZGet, GetRequest{
+		ID:  s.spaceID,
+		Key: []byte(key),
+	}.Encode(), true)
+	if err != nil {
+		return nil, unfilterErr(err)
+	}
+	var v CodedValue
+	if err := v.Decode(rsp.Data); err != nil {
+		return nil, err
+	}
+	return decodeValue(v.Codec, v.Data, s.wire.maxValueSize())
+}
+
+func (s KV) callCheckedGet(ctx context.Context, key string) ([]byte, error) {
+	rsp, err := s.call(ctx, mCGet, CheckedGetRequest{
+		ID:       s.spaceID,
+		Key:      []byte(key),
+		Checksum: s.sums.kind,
+		Compress: s.wire.ready(ctx, s),
+	}.Encode(), true)
+	if err != nil {
+		return nil, unfilterErr(err)
+	}
+	var v CodedValue
+	if err := v.Decode(rsp.Data); err != nil {
+		return nil, err
+	} else if v.Checksum != s.sums.kind {
+		return nil, fmt.Errorf("cget: got checksum %v, want %v", v.Checksum, s.sums.kind)
+	}
+	data, err := decodeValue(v.Codec, v.Data, s.wire.maxValueSize())
+	if err != nil {
+		return nil, err
+	}
+	if err := v.Checksum.verify(key, v.Sum, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
 // Has implements a method of [blob.KV].
 func (s KV) Has(ctx context.Context, keys ...string) (blob.KeySet, error) {
 	if len(keys) == 0 {
 		return nil, nil // no sense calling the peer in this case
 	}
-	rsp, err := s.peer.Call(ctx, s.method(mHas), HasRequest{
-		ID:   s.spaceID,
-		Keys: keys,
-	}.Encode())
+	var out blob.KeySet
+	if s.batch != nil {
+		present, err := s.batchHas(ctx, keys)
+		if err != nil {
+			return nil, err
+		}
+		for i, key := range keys {
+			if present[i] {
+				out.Add(key)
+			}
+		}
+		return out, nil
+	}
+	srsp, err := s.callHas(ctx, keys)
 	if err != nil {
 		return nil, err
 	}
-	srsp := HasResponse(rsp.Data)
-	if srsp.Count() < len(keys) {
-		return nil, fmt.Errorf("has: got %d results, want %d", srsp.Count(), len(keys))
-	}
-	var out blob.KeySet
 	for i, key := range keys {
 		if srsp.IsSet(i) {
 			out.Add(key)
@@ -139,26 +411,65 @@ func (s KV) Has(ctx context.Context, keys ...string) (blob.KeySet, error) {
 	return out, nil
 }
 
+func (s KV) callHas(ctx context.Context, keys []string) (HasResponse, error) {
+	rsp, err := s.call(ctx, mHas, HasRequest{
+		ID:   s.spaceID,
+		Keys: keys,
+	}.Encode(), true)
+	if err != nil {
+		return nil, unfilterErr(err)
+	}
+	srsp := HasResponse(rsp.Data)
+	if srsp.Count() < len(keys) {
+		return nil, fmt.Errorf("has: got %d results, want %d", srsp.Count(), len(keys))
+	}
+	return srsp, nil
+}
+
 // Put implements a method of [blob.KV].
 func (s KV) Put(ctx context.Context, opts blob.PutOptions) error {
-	_, err := s.peer.Call(ctx, s.method(mPut), PutRequest{
+	defer s.invalidate(opts.Key)
+	preq := PutRequest{
 		ID:      s.spaceID,
 		Key:     []byte(opts.Key),
 		Data:    opts.Data,
 		Replace: opts.Replace,
-	}.Encode())
+	}
+	if s.sums.ready(ctx, s) {
+		sum, err := s.sums.kind.sum(opts.Data)
+		if err != nil {
+			return err
+		}
+		preq.Checksum, preq.Sum = s.sums.kind, sum
+	}
+	if s.wire.ready(ctx, s) {
+		if z, ok := s.wire.opts.compress(opts.Data); ok {
+			preq.Codec, preq.Data = CodecDeflate, z
+		}
+	}
+	_, err := s.call(ctx, mPut, preq.Encode(), opts.Replace)
 	return unfilterErr(err)
 }
 
 // Delete implements a method of [blob.KV].
 func (s KV) Delete(ctx context.Context, key string) error {
-	_, err := s.peer.Call(ctx, s.method(mDelete), DeleteRequest{
+	defer s.invalidate(key)
+	_, err := s.call(ctx, mDelete, DeleteRequest{
 		ID:  s.spaceID,
 		Key: []byte(key),
-	}.Encode())
+	}.Encode(), false)
 	return unfilterErr(err)
 }
 
+// invalidate removes the cached values of keys, if any.
+func (s KV) invalidate(keys ...string) {
+	if s.cache != nil {
+		for _, key := range keys {
+			s.cache.remove(s.spaceID, key)
+		}
+	}
+}
+
 // List implements a method of [blob.KV].
 func (s KV) List(ctx context.Context, start string) iter.Seq2[string, error] {
 	return func(yield func(string, error) bool) {
@@ -166,12 +477,12 @@ func (s KV) List(ctx context.Context, start string) iter.Seq2[string, error] {
 		for {
 			// Fetch another batch of keys.
 			var rsp ListResponse
-			if lres, err := s.peer.Call(ctx, s.method(mList), ListRequest{
+			if lres, err := s.call(ctx, mList, ListRequest{
 				ID:    s.spaceID,
 				Start: []byte(next),
 				Count: count,
-			}.Encode()); err != nil {
-				yield("", err)
+			}.Encode(), true); err != nil {
+				yield("", unfilterErr(err))
 				return
 			} else if err := rsp.Decode(lres.Data); err != nil {
 				yield("", err)
@@ -200,22 +511,150 @@ func (s KV) List(ctx context.Context, start string) iter.Seq2[string, error] {
 
 // Len implements a method of [blob.KV].
 func (s KV) Len(ctx context.Context) (int64, error) {
-	rsp, err := s.peer.Call(ctx, s.method(mLen), LenRequest{
+	rsp, err := s.call(ctx, mLen, LenRequest{
 		ID: s.spaceID,
-	}.Encode())
+	}.Encode(), true)
 	if err != nil {
-		return 0, err
+		return 0, unfilterErr(err)
 	} else if len(rsp.Data) == 0 {
 		return 0, errors.New("len: invalid response format")
 	}
 	return unpackInt64(rsp.Data), nil
 }
 
+// DropOptions are the arguments to the [KV.Drop] method.
+type DropOptions struct {
+	// As a safeguard, Confirm must be true and Keyspace must match the name of
+	// the keyspace being dropped. Otherwise, the drop request fails without
+	// effect.
+	Confirm  bool
+	Keyspace string
+
+	// If set, only keys beginning with this prefix are dropped.
+	Prefix string
+}
+
+// Drop removes all the keys from the keyspace, or if opts.Prefix is set, all
+// the keys having that prefix, in a single call to the service. It reports
+// the number of keys removed.
+func (s KV) Drop(ctx context.Context, opts DropOptions) (int64, error) {
+	if s.cache != nil {
+		s.cache.clear() // the dropped keys are not known to the client
+	}
+	rsp, err := s.call(ctx, mDrop, DropRequest{
+		ID:       s.spaceID,
+		Confirm:  opts.Confirm,
+		Keyspace: []byte(opts.Keyspace),
+		Prefix:   []byte(opts.Prefix),
+	}.Encode(), false)
+	if err != nil {
+		return 0, unfilterErr(err)
+	} else if len(rsp.Data) == 0 {
+		return 0, errors.New("drop: invalid response format")
+	}
+	return unpackInt64(rsp.Data), nil
+}
+
+// CopyOptions are the arguments to the [KV.CopyTo] and [KV.MoveTo] methods.
+type CopyOptions struct {
+	Keys    []string // the keys to transfer
+	Replace bool     // whether to replace existing values in the target
+}
+
+// CopyTo copies the values of the specified keys from s to dst, without
+// transferring the data through the client. It reports the number of keys
+// copied; copying stops at the first key that fails, and the number of keys
+// copied before the failure is reported along with the error. Both s and dst
+// must belong to the same service.
+func (s KV) CopyTo(ctx context.Context, dst KV, opts CopyOptions) (int64, error) {
+	return s.transfer(ctx, mCopy, dst, opts)
+}
+
+// MoveTo behaves as [KV.CopyTo], but also deletes each key from s once it has
+// been copied to dst.
+func (s KV) MoveTo(ctx context.Context, dst KV, opts CopyOptions) (int64, error) {
+	return s.transfer(ctx, mMove, dst, opts)
+}
+
+func (s KV) transfer(ctx context.Context, m string, dst KV, opts CopyOptions) (int64, error) {
+	if dst.peer != s.peer {
+		return 0, fmt.Errorf("%s: target keyspace is on a different peer", m)
+	}
+	dst.invalidate(opts.Keys...)
+	if m == mMove {
+		s.invalidate(opts.Keys...)
+	}
+	rsp, err := s.call(ctx, m, CopyRequest{
+		Src:     s.spaceID,
+		Dst:     dst.spaceID,
+		Replace: opts.Replace,
+		Keys:    opts.Keys,
+	}.Encode(), false)
+	if err != nil {
+		return untransferErr(err)
+	} else if len(rsp.Data) == 0 {
+		return 0, fmt.Errorf("%s: invalid response format", m)
+	}
+	return unpackInt64(rsp.Data), nil
+}
+
+// untransferErr recovers the number of keys transferred from the error
+// reported by a copy or move, along with the error. The data of the error are
+// the key that failed, prefixed by its length, followed by the count.
+func untransferErr(err error) (int64, error) {
+	ce, ok := errors.AsType[*chirp.CallError](err)
+	if !ok || ce.Err != nil {
+		return 0, unfilterErr(err)
+	}
+	s := packet.NewScanner(ce.Data)
+	key, serr := s.VGet()
+	if serr != nil {
+		return 0, unfilterErr(err)
+	}
+	kce := *ce // don't modify the original
+	kce.Data = key
+	return unpackInt64(s.Rest()), unfilterErr(&kce)
+}
+
+// Digest reports digests of the keys of s in the range from start (inclusive)
+// to end (exclusive), partitioned into at most split subranges. If end == ""
+// the range has no upper bound. See [Service.Digest] for details.
+func (s KV) Digest(ctx context.Context, start, end string, split int) ([]DigestRange, error) {
+	rsp, err := s.call(ctx, mDigest, DigestRequest{
+		ID:    s.spaceID,
+		Split: split,
+		Start: []byte(start),
+		End:   []byte(end),
+	}.Encode(), true)
+	if err != nil {
+		return nil, unfilterErr(err)
+	}
+	var drsp DigestResponse
+	if err := drsp.Decode(rsp.Data); err != nil {
+		return nil, err
+	} else if len(drsp.Ranges) == 0 {
+		return nil, errors.New("digest: invalid response format")
+	}
+	return drsp.Ranges, nil
+}
+
 // Status calls the status method of the store service.
 func (s KV) Status(ctx context.Context) ([]byte, error) {
-	rsp, err := s.peer.Call(ctx, s.method(mStatus), nil)
+	rsp, err := s.call(ctx, mStatus, nil, true)
 	if err != nil {
-		return nil, err
+		return nil, unfilterErr(err)
 	}
 	return rsp.Data, nil
 }
+
+// Checksum reports the kind of checksum that values sent to and from the
+// service carry (see [StoreOptions]), checking whether the service supports it
+// if that has not yet been done. It reports ChecksumNone if checksums are not
+// enabled, the service does not support them, or support could not yet be
+// determined.
+func (s KV) Checksum(ctx context.Context) Checksum {
+	if s.sums.ready(ctx, s) {
+		return s.sums.kind
+	}
+	return ChecksumNone
+}
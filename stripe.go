@@ -0,0 +1,54 @@
+package chirpstore
+
+import (
+	"errors"
+	"sync/atomic"
+
+	"github.com/creachadair/chirp"
+)
+
+// NewStripedStore constructs a Store that delegates through several peers
+// connected to the same service, to spread calls across multiple connections
+// ("striping"). A single peer serializes its packets on one connection, which
+// can limit throughput for bulk transfers.
+//
+// Each keyspace and substore is opened on every peer, and each call is sent
+// to the next peer in turn. Closing the store stops all the peers.
+func NewStripedStore(peers []*chirp.Peer, opts *StoreOptions) (Store, error) {
+	if len(peers) == 0 {
+		return Store{}, errors.New("no peers provided")
+	}
+	return newStore(peers, opts), nil
+}
+
+// A stripe is a set of peers connected to the same service, among which calls
+// are distributed in rotation.
+type stripe struct {
+	peers []*chirp.Peer
+	next  atomic.Uint64
+}
+
+// newStripe returns a stripe for peers, or nil if there is only one.
+func newStripe(peers []*chirp.Peer) *stripe {
+	if len(peers) < 2 {
+		return nil
+	}
+	return &stripe{peers: peers}
+}
+
+// pick returns the next peer from s, or def if s == nil.
+func (s *stripe) pick(def *chirp.Peer) *chirp.Peer {
+	if s == nil {
+		return def
+	}
+	i := s.next.Add(1) - 1
+	return s.peers[i%uint64(len(s.peers))]
+}
+
+// all returns all the peers of s, or just def if s == nil.
+func (s *stripe) all(def *chirp.Peer) []*chirp.Peer {
+	if s == nil {
+		return []*chirp.Peer{def}
+	}
+	return s.peers
+}
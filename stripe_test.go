@@ -0,0 +1,103 @@
+package chirpstore_test
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+
+	"github.com/creachadair/chirp"
+	"github.com/creachadair/chirpstore"
+	"github.com/creachadair/ffs/blob"
+	"github.com/creachadair/ffs/blob/memstore"
+	"github.com/creachadair/ffs/blob/storetest"
+)
+
+// stripedService returns n client peers for a single service, and counters
+// of the requests sent on each.
+func stripedService(t *testing.T, n int) ([]*chirp.Peer, []*atomic.Int64) {
+	t.Helper()
+	svc := chirpstore.NewService(memstore.New(nil), nil)
+	var out []*chirp.Peer
+	var counts []*atomic.Int64
+	for range n {
+		peer := newTestService(t, svc)
+		count := new(atomic.Int64)
+		peer.LogPackets(func(pkt chirp.Packet, dir chirp.PacketDir) {
+			if dir == chirp.Send && pkt.Type == chirp.PacketRequest {
+				count.Add(1)
+			}
+		})
+		out = append(out, peer)
+		counts = append(counts, count)
+	}
+	return out, counts
+}
+
+func TestStriped(t *testing.T) {
+	t.Run("Store", func(t *testing.T) {
+		ps, _ := stripedService(t, 3)
+		rs, err := chirpstore.NewStripedStore(ps, nil)
+		if err != nil {
+			t.Fatalf("NewStripedStore: unexpected error: %v", err)
+		}
+		storetest.Run(t, rs)
+	})
+
+	t.Run("Distribute", func(t *testing.T) {
+		ctx := t.Context()
+		ps, counts := stripedService(t, 3)
+		rs, err := chirpstore.NewStripedStore(ps, nil)
+		if err != nil {
+			t.Fatalf("NewStripedStore: unexpected error: %v", err)
+		}
+		sub, err := rs.Sub(ctx, "sub")
+		if err != nil {
+			t.Fatalf("Sub: unexpected error: %v", err)
+		}
+		kv := mustKV(t, sub, "data")
+
+		// Opening the substore and keyspace sends one request to each peer.
+		for i, c := range counts {
+			if got := c.Swap(0); got != 2 {
+				t.Errorf("Peer %d: got %d open requests, want 2", i, got)
+			}
+		}
+
+		const numCalls = 30
+		for i := range numCalls {
+			key := fmt.Sprintf("key-%d", i)
+			if err := kv.Put(ctx, blob.PutOptions{Key: key, Data: []byte(key)}); err != nil {
+				t.Fatalf("Put %q: unexpected error: %v", key, err)
+			}
+		}
+		for i, c := range counts {
+			if got := c.Load(); got != numCalls/3 {
+				t.Errorf("Peer %d: got %d requests, want %d", i, got, numCalls/3)
+			}
+		}
+		if got, err := kv.Get(ctx, "key-5"); err != nil || string(got) != "key-5" {
+			t.Errorf("Get: got (%q, %v), want key-5", got, err)
+		}
+	})
+
+	t.Run("Mismatch", func(t *testing.T) {
+		// Peers for different services may assign different IDs.
+		a, b := newTestService(t, nil), newTestService(t, nil)
+		if _, err := chirpstore.NewStore(b, nil).KV(t.Context(), "other"); err != nil {
+			t.Fatalf("KV: unexpected error: %v", err)
+		}
+		rs, err := chirpstore.NewStripedStore([]*chirp.Peer{a, b}, nil)
+		if err != nil {
+			t.Fatalf("NewStripedStore: unexpected error: %v", err)
+		}
+		if kv, err := rs.KV(t.Context(), "data"); err == nil {
+			t.Errorf("KV: got %v, want error", kv)
+		}
+	})
+
+	t.Run("NoPeers", func(t *testing.T) {
+		if _, err := chirpstore.NewStripedStore(nil, nil); err == nil {
+			t.Error("NewStripedStore: got nil error, want error")
+		}
+	})
+}
@@ -0,0 +1,56 @@
+package chirpstore
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+
+	"github.com/creachadair/chirp"
+	"github.com/creachadair/chirp/channel"
+)
+
+// ServeTLS is like [Service.Serve], but accepts TLS connections from lst
+// using the given config. To require and verify client certificates, set the
+// ClientAuth and ClientCAs fields of config. The handlers for calls from a
+// verified client can recover its certificate using [PeerCertificate].
+func (s *Service) ServeTLS(ctx context.Context, lst net.Listener, config *tls.Config) error {
+	return s.Serve(ctx, tls.NewListener(lst, config))
+}
+
+// DialTLS connects to the service at addr over TLS using the given config, and
+// returns a peer connected to it. The addr has the form accepted by
+// [chirp.SplitAddress]. To present a client certificate, set the Certificates
+// field of config.
+func DialTLS(ctx context.Context, addr string, config *tls.Config) (*chirp.Peer, error) {
+	ntype, addr := chirp.SplitAddress(addr)
+	conn, err := (&tls.Dialer{Config: config}).DialContext(ctx, ntype, addr)
+	if err != nil {
+		return nil, err
+	}
+	return chirp.NewPeer().Start(channel.IO(conn, conn)), nil
+}
+
+// tlsStateKey is the context key for a function that reports the TLS state
+// of the connection to a remote peer.
+type tlsStateKey struct{}
+
+// withTLSState returns a child of ctx that reports the given TLS state.
+func withTLSState(ctx context.Context, state func() tls.ConnectionState) context.Context {
+	return context.WithValue(ctx, tlsStateKey{}, state)
+}
+
+// PeerCertificate returns the verified certificate presented by the remote
+// peer for the call whose context is ctx, or nil if the peer did not present a
+// verified certificate. Its result is valid for contexts passed to the method
+// handlers of a [Service], and to the Authorize hook of its options.
+func PeerCertificate(ctx context.Context) *x509.Certificate {
+	state, ok := ctx.Value(tlsStateKey{}).(func() tls.ConnectionState)
+	if !ok {
+		return nil
+	}
+	if cs := state(); len(cs.VerifiedChains) != 0 {
+		return cs.VerifiedChains[0][0]
+	}
+	return nil
+}
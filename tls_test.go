@@ -0,0 +1,167 @@
+package chirpstore_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/creachadair/chirpstore"
+	"github.com/creachadair/ffs/blob"
+	"github.com/creachadair/ffs/blob/memstore"
+)
+
+// testCA is a certificate authority for tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Generate CA key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Create CA certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Parse CA certificate: %v", err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testCA{cert: cert, key: key, pool: pool}
+}
+
+// issue returns a certificate for the given common name signed by ca, valid
+// for both server and client authentication on the loopback address.
+func (ca *testCA) issue(t *testing.T, name string) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Generate key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("Create certificate: %v", err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestTLS(t *testing.T) {
+	ca := newTestCA(t)
+
+	var μ sync.Mutex
+	var callers []string
+	svc := chirpstore.NewService(memstore.New(nil), &chirpstore.ServiceOptions{
+		Authorize: func(ctx context.Context, method string) error {
+			cert := chirpstore.PeerCertificate(ctx)
+			if cert == nil {
+				return errors.New("no client certificate")
+			}
+			μ.Lock()
+			defer μ.Unlock()
+			callers = append(callers, cert.Subject.CommonName+" "+method)
+			return nil
+		},
+	})
+
+	lst, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- svc.ServeTLS(ctx, lst, &tls.Config{
+			Certificates: []tls.Certificate{ca.issue(t, "server")},
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+			ClientCAs:    ca.pool,
+		})
+	}()
+	defer func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("ServeTLS: unexpected error: %v", err)
+		}
+	}()
+	addr := lst.Addr().String()
+
+	t.Run("Verified", func(t *testing.T) {
+		peer, err := chirpstore.DialTLS(t.Context(), addr, &tls.Config{
+			Certificates: []tls.Certificate{ca.issue(t, "alice")},
+			RootCAs:      ca.pool,
+		})
+		if err != nil {
+			t.Fatalf("DialTLS: %v", err)
+		}
+		defer peer.Stop()
+
+		kv := mustKV(t, chirpstore.NewStore(peer, nil), "test")
+		if err := kv.Put(t.Context(), blob.PutOptions{Key: "k", Data: []byte("v")}); err != nil {
+			t.Fatalf("Put: unexpected error: %v", err)
+		}
+		μ.Lock()
+		defer μ.Unlock()
+		if diff := cmp.Diff(callers, []string{"alice kv", "alice put"}); diff != "" {
+			t.Errorf("Callers (-got, +want):\n%s", diff)
+		}
+	})
+
+	t.Run("NoClientCert", func(t *testing.T) {
+		peer, err := chirpstore.DialTLS(t.Context(), addr, &tls.Config{RootCAs: ca.pool})
+		if err != nil {
+			t.Fatalf("DialTLS: %v", err)
+		}
+		defer peer.Stop()
+
+		st := chirpstore.NewStore(peer, nil)
+		if kv, err := st.KV(t.Context(), "test"); err == nil {
+			t.Errorf("KV: got %v, want error", kv)
+		}
+	})
+
+	t.Run("UnknownServer", func(t *testing.T) {
+		peer, err := chirpstore.DialTLS(t.Context(), addr, &tls.Config{
+			Certificates: []tls.Certificate{ca.issue(t, "alice")},
+			RootCAs:      newTestCA(t).pool,
+		})
+		if err == nil {
+			peer.Stop()
+			t.Error("DialTLS: got nil error, want error")
+		}
+	})
+}
@@ -16,10 +16,35 @@ import (
 )
 
 const (
-	codeKeyExists   = 400
-	codeKeyNotFound = 404
+	codeKeyExists        = 400
+	codeKeyNotFound      = 404
+	codeChecksumMismatch = 422
+	codeUnavailable      = 503
 )
 
+// ErrUnavailable is reported by client calls rejected by a service that is
+// shutting down (see [Service.Shutdown]). The call had no effect, so it is
+// safe to retry, for example by connecting to another instance.
+var ErrUnavailable = errors.New("service unavailable")
+
+// ErrChecksumMismatch is the underlying error of a [ChecksumError].
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// ChecksumError is the concrete type of errors reporting that a value did not
+// match the checksum sent with it, either by the service for a value sent by
+// Put, or by the client for a value returned by Get. It wraps
+// [ErrChecksumMismatch].
+type ChecksumError struct {
+	Key string // the key of the corrupted value
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("key %q: %v", e.Key, ErrChecksumMismatch)
+}
+
+// Unwrap reports the underlying error of e, which is [ErrChecksumMismatch].
+func (e *ChecksumError) Unwrap() error { return ErrChecksumMismatch }
+
 // IDKeyRequest is a shared type for requests that take an ID and a key.
 type IDKeyRequest struct {
 	ID  int
@@ -139,18 +164,36 @@ type PutRequest struct {
 	Key     []byte
 	Data    []byte
 	Replace bool
+	Codec   Codec // the encoding of Data
+
+	// If not ChecksumNone, Sum is the checksum of the plain value.
+	Checksum Checksum
+	Sum      []byte
 
 	// Encoding:
-	// [V] id [1] replace [Vn] keylen [n] key [rest] data
+	// [V] id [1] flags [Vn] keylen [n] key [Vm] sumlen [m] sum [rest] data
+	//
+	// Bit 0 of flags is set for replace, bits 1-3 hold the codec, and bits
+	// 4-6 hold the checksum. The sum is present only if the checksum is not
+	// ChecksumNone. A client sends a codec or checksum other than the default
+	// only to a service that reports supporting it (see [Service.Codecs] and
+	// [Service.Checksums]).
 }
 
 // Encode converts p into a binary string for request data.
 func (p PutRequest) Encode() []byte {
 	var b packet.Builder
-	b.Grow(packet.Vint30(p.ID).Size() + 1 + packet.VLen(len(p.Key)) + len(p.Data))
+	b.Grow(packet.Vint30(p.ID).Size() + 1 + packet.VLen(len(p.Key)) + packet.VLen(len(p.Sum)) + len(p.Data))
 	b.Vint30(uint32(p.ID))
-	b.Bool(p.Replace)
+	flags := byte(p.Codec&7)<<1 | byte(p.Checksum&7)<<4
+	if p.Replace {
+		flags |= 1
+	}
+	b.Put(flags)
 	b.VPut(p.Key)
+	if p.Checksum != ChecksumNone {
+		b.VPut(p.Sum)
+	}
 	b.Put(p.Data...)
 	return b.Bytes()
 }
@@ -163,18 +206,232 @@ func (p *PutRequest) Decode(data []byte) error {
 		return fmt.Errorf("invalid put request: %w", err)
 	}
 	p.ID = id
-	p.Replace, err = s.Bool()
+	flags, err := s.Byte()
 	if err != nil {
 		return fmt.Errorf("invalid put request: %w", err)
 	}
+	if flags&0x80 != 0 {
+		return errors.New("invalid put request: unknown flags")
+	}
+	p.Replace = flags&1 != 0
+	p.Codec = Codec(flags >> 1 & 7)
+	p.Checksum = Checksum(flags >> 4 & 7)
 	p.Key, err = s.VGet()
 	if err != nil {
 		return fmt.Errorf("invalid put request: %w", err)
 	}
+	p.Sum = nil
+	if p.Checksum != ChecksumNone {
+		p.Sum, err = s.VGet()
+		if err != nil {
+			return fmt.Errorf("invalid put request: malformed checksum: %w", err)
+		}
+	}
 	p.Data = s.Rest()
 	return nil
 }
 
+// DropRequest is an encoding wrapper for the arguments of the Drop method.
+type DropRequest struct {
+	ID       int
+	Confirm  bool
+	Keyspace []byte // the name of the keyspace, to confirm
+	Prefix   []byte
+
+	// Encoding:
+	// [V] id [1] confirm [Vk] klen [k] keyspace [rest] prefix
+}
+
+// Encode converts d into a binary string for request data.
+func (d DropRequest) Encode() []byte {
+	var b packet.Builder
+	b.Grow(packet.Vint30(d.ID).Size() + 1 + packet.VLen(len(d.Keyspace)) + len(d.Prefix))
+	b.Vint30(uint32(d.ID))
+	b.Bool(d.Confirm)
+	b.VPut(d.Keyspace)
+	b.Put(d.Prefix...)
+	return b.Bytes()
+}
+
+// Decode data from binary format and replace the contents of d.
+func (d *DropRequest) Decode(data []byte) error {
+	s := packet.NewScanner(data)
+	id, err := s.Vint30()
+	if err != nil {
+		return fmt.Errorf("invalid drop request: %w", err)
+	}
+	d.ID = id
+	d.Confirm, err = s.Bool()
+	if err != nil {
+		return fmt.Errorf("invalid drop request: %w", err)
+	}
+	d.Keyspace, err = s.VGet()
+	if err != nil {
+		return fmt.Errorf("invalid drop request: %w", err)
+	}
+	d.Prefix = s.Rest()
+	return nil
+}
+
+// CopyRequest is an encoding wrapper for the arguments of the Copy and Move
+// methods.
+type CopyRequest struct {
+	Src     int
+	Dst     int
+	Replace bool
+	Keys    []string
+
+	// Encoding:
+	// [V] src [V] dst [1] replace |: [Vk] klen [k] key :|
+}
+
+// MoveRequest is the encoding wrapper for a Move request.
+type MoveRequest = CopyRequest
+
+// Encode converts c into a binary string for request data.
+func (c CopyRequest) Encode() []byte {
+	size := packet.Vint30(c.Src).Size() + packet.Vint30(c.Dst).Size() + 1
+	for _, key := range c.Keys {
+		size += packet.VLen(len(key))
+	}
+	var b packet.Builder
+	b.Grow(size)
+	b.Vint30(uint32(c.Src))
+	b.Vint30(uint32(c.Dst))
+	b.Bool(c.Replace)
+	for _, key := range c.Keys {
+		b.VPutString(key)
+	}
+	return b.Bytes()
+}
+
+// Decode data from binary format and replace the contents of c.
+func (c *CopyRequest) Decode(data []byte) error {
+	s := packet.NewScanner(data)
+	src, err := s.Vint30()
+	if err != nil {
+		return fmt.Errorf("invalid copy request: %w", err)
+	}
+	dst, err := s.Vint30()
+	if err != nil {
+		return fmt.Errorf("invalid copy request: %w", err)
+	}
+	c.Src, c.Dst = src, dst
+	c.Replace, err = s.Bool()
+	if err != nil {
+		return fmt.Errorf("invalid copy request: %w", err)
+	}
+	c.Keys = c.Keys[:0]
+	for s.Len() != 0 {
+		key, err := s.VGet()
+		if err != nil {
+			return fmt.Errorf("invalid copy request: malformed key: %w", err)
+		}
+		c.Keys = append(c.Keys, string(key))
+	}
+	return nil
+}
+
+// DigestRequest is an encoding wrapper for the arguments of the Digest method.
+type DigestRequest struct {
+	ID    int
+	Split int
+	Start []byte
+	End   []byte // empty means no upper bound
+
+	// Encoding:
+	// [V] id [V] split [Vs] slen [s] start [rest] end
+}
+
+// Encode converts d into a binary string for request data.
+func (d DigestRequest) Encode() []byte {
+	var b packet.Builder
+	b.Grow(packet.Vint30(d.ID).Size() + packet.Vint30(d.Split).Size() +
+		packet.VLen(len(d.Start)) + len(d.End))
+	b.Vint30(uint32(d.ID))
+	b.Vint30(uint32(d.Split))
+	b.VPut(d.Start)
+	b.Put(d.End...)
+	return b.Bytes()
+}
+
+// Decode data from binary format and replace the contents of d.
+func (d *DigestRequest) Decode(data []byte) error {
+	s := packet.NewScanner(data)
+	id, err := s.Vint30()
+	if err != nil {
+		return fmt.Errorf("invalid digest request: %w", err)
+	}
+	d.ID = id
+	d.Split, err = s.Vint30()
+	if err != nil {
+		return fmt.Errorf("invalid digest request: %w", err)
+	}
+	d.Start, err = s.VGet()
+	if err != nil {
+		return fmt.Errorf("invalid digest request: %w", err)
+	}
+	d.End = s.Rest()
+	return nil
+}
+
+// DigestResponse is an encoding wrapper for the Digest method response.
+type DigestResponse struct {
+	Ranges []DigestRange
+
+	// Encoding:
+	// |: [Vs] slen [s] start [Vc] clen [c] count [Vh] hlen [h] sum :|
+	//
+	// The count is a little-endian integer with no leading zeroes.
+}
+
+// A DigestRange is the digest of a contiguous range of keys. The range begins
+// at Start (inclusive) and ends at the start of the following range, or the
+// end of the requested range if there is no following range.
+type DigestRange struct {
+	Start []byte
+	Count int64  // the number of keys in the range
+	Sum   []byte // the digest of the keys in the range
+}
+
+// Encode converts r into a binary string for response data.
+func (r DigestResponse) Encode() []byte {
+	var size int
+	for _, dr := range r.Ranges {
+		size += packet.VLen(len(dr.Start)) + packet.VLen(8) + packet.VLen(len(dr.Sum))
+	}
+	var b packet.Builder
+	b.Grow(size)
+	for _, dr := range r.Ranges {
+		b.VPut(dr.Start)
+		b.VPut(packInt64(dr.Count))
+		b.VPut(dr.Sum)
+	}
+	return b.Bytes()
+}
+
+// Decode data from binary format and replace the contents of r.
+func (r *DigestResponse) Decode(data []byte) error {
+	s := packet.NewScanner(data)
+	r.Ranges = r.Ranges[:0]
+	for s.Len() != 0 {
+		start, err := s.VGet()
+		if err != nil {
+			return fmt.Errorf("invalid digest response: %w", err)
+		}
+		count, err := s.VGet()
+		if err != nil {
+			return fmt.Errorf("invalid digest response: %w", err)
+		}
+		sum, err := s.VGet()
+		if err != nil {
+			return fmt.Errorf("invalid digest response: %w", err)
+		}
+		r.Ranges = append(r.Ranges, DigestRange{Start: start, Count: unpackInt64(count), Sum: sum})
+	}
+	return nil
+}
+
 // ListRequest is the an encoding wrapper for the arguments to the List method.
 type ListRequest struct {
 	ID    int
@@ -268,10 +525,103 @@ type SubResponse = IDOnly
 // LenRequest is the encoding wrapper for a Len request.
 type LenRequest = IDOnly
 
+// NamesRequest is the encoding wrapper for a Keyspaces or Substores request.
+type NamesRequest = IDOnly
+
+// NamesResponse is the encoding wrapper for a Keyspaces or Substores response.
+type NamesResponse struct {
+	Names []string
+
+	// Encoding:
+	// |: [Vn] nlen [n] name :|
+}
+
+// Encode converts r into a binary string for response data.
+func (r NamesResponse) Encode() []byte {
+	var size int
+	for _, name := range r.Names {
+		size += packet.VLen(len(name))
+	}
+	var b packet.Builder
+	b.Grow(size)
+	for _, name := range r.Names {
+		b.VPutString(name)
+	}
+	return b.Bytes()
+}
+
+// Decode data from binary format and replace the contents of r.
+func (r *NamesResponse) Decode(data []byte) error {
+	s := packet.NewScanner(data)
+	r.Names = r.Names[:0]
+	for s.Len() != 0 {
+		name, err := s.VGet()
+		if err != nil {
+			return fmt.Errorf("invalid names response: %w", err)
+		}
+		r.Names = append(r.Names, string(name))
+	}
+	return nil
+}
+
+// MultiGetRequest is an encoding wrapper for the arguments of the MultiGet
+// method. It has the same encoding as [HasRequest].
+type MultiGetRequest = HasRequest
+
+// MultiGetResponse is the encoding wrapper for a MultiGet response.
+// Values[i] is the value of the ith requested key, or nil if that key was not
+// found. The value of a key that was found is never nil, even if it is empty.
+type MultiGetResponse struct {
+	Values [][]byte
+
+	// Encoding:
+	// |: [1] found [Vn] vlen [n] value :|
+}
+
+// Encode converts r into a binary string for response data.
+func (r MultiGetResponse) Encode() []byte {
+	var size int
+	for _, v := range r.Values {
+		size += 1 + packet.VLen(len(v))
+	}
+	var b packet.Builder
+	b.Grow(size)
+	for _, v := range r.Values {
+		b.Bool(v != nil)
+		b.VPut(v)
+	}
+	return b.Bytes()
+}
+
+// Decode data from binary format and replace the contents of r.
+func (r *MultiGetResponse) Decode(data []byte) error {
+	s := packet.NewScanner(data)
+	r.Values = r.Values[:0]
+	for s.Len() != 0 {
+		found, err := s.Bool()
+		if err != nil {
+			return fmt.Errorf("invalid mget response: %w", err)
+		}
+		v, err := s.VGet()
+		if err != nil {
+			return fmt.Errorf("invalid mget response: malformed value: %w", err)
+		}
+		if !found {
+			v = nil
+		} else if v == nil {
+			v = []byte{}
+		}
+		r.Values = append(r.Values, v)
+	}
+	return nil
+}
+
 func filterErr(err error) error {
 	var kerr *blob.KeyError
 
-	if blob.IsKeyNotFound(err) {
+	if ce, ok := errors.AsType[*ChecksumError](err); ok {
+		return &chirp.ErrorData{Code: codeChecksumMismatch, Message: "checksum mismatch", Data: []byte(ce.Key)}
+	} else if blob.IsKeyNotFound(err) {
 		ed := &chirp.ErrorData{Code: codeKeyNotFound, Message: "key not found"}
 		if errors.As(err, &kerr) {
 			ed.Data = []byte(kerr.Key)
@@ -301,6 +651,10 @@ func unfilterErr(err error) error {
 				return blob.KeyNotFound(key)
 			}
 			return blob.ErrKeyNotFound
+		} else if ce.Code == codeChecksumMismatch {
+			return &ChecksumError{Key: key}
+		} else if ce.Code == codeUnavailable {
+			return fmt.Errorf("%w: %s", ErrUnavailable, ce.Message)
 		}
 		// fall through
 	}
@@ -327,3 +681,146 @@ func unpackInt64(buf []byte) int64 {
 	}
 	return int64(v)
 }
+
+// A Codec identifies the encoding of a value sent between a client and a
+// service, or stored by a service.
+type Codec byte
+
+// Codecs understood by this package.
+const (
+	CodecNone    Codec = 0 // uncompressed
+	CodecDeflate Codec = 1 // compressed with DEFLATE (RFC 1951)
+)
+
+func (c Codec) String() string {
+	switch c {
+	case CodecNone:
+		return "none"
+	case CodecDeflate:
+		return "deflate"
+	default:
+		return fmt.Sprintf("codec(%d)", byte(c))
+	}
+}
+
+// A Checksum identifies the kind of checksum sent with a value between a
+// client and a service.
+type Checksum byte
+
+// Checksums understood by this package.
+const (
+	ChecksumNone   Checksum = 0 // no checksum
+	ChecksumCRC32C Checksum = 1 // CRC-32 with the Castagnoli polynomial, big-endian
+	ChecksumSHA256 Checksum = 2 // SHA-256 (FIPS 180-4)
+)
+
+func (c Checksum) String() string {
+	switch c {
+	case ChecksumNone:
+		return "none"
+	case ChecksumCRC32C:
+		return "crc32c"
+	case ChecksumSHA256:
+		return "sha256"
+	default:
+		return fmt.Sprintf("checksum(%d)", byte(c))
+	}
+}
+
+// CheckedGetRequest is an encoding wrapper for the arguments of the CheckedGet
+// method.
+type CheckedGetRequest struct {
+	ID       int
+	Key      []byte
+	Checksum Checksum // the checksum to report with the value
+	Compress bool     // whether the client accepts a compressed value
+
+	// Encoding:
+	// [V] id [1] flags [rest] key
+	//
+	// Bit 0 of flags is set for compress, and bits 1-3 hold the checksum.
+}
+
+// Encode converts r into a binary string for request data.
+func (r CheckedGetRequest) Encode() []byte {
+	var b packet.Builder
+	b.Grow(packet.Vint30(r.ID).Size() + 1 + len(r.Key))
+	b.Vint30(uint32(r.ID))
+	flags := byte(r.Checksum&7) << 1
+	if r.Compress {
+		flags |= 1
+	}
+	b.Put(flags)
+	b.Put(r.Key...)
+	return b.Bytes()
+}
+
+// Decode data from binary format and replace the contents of r.
+func (r *CheckedGetRequest) Decode(data []byte) error {
+	s := packet.NewScanner(data)
+	id, err := s.Vint30()
+	if err != nil {
+		return fmt.Errorf("invalid cget request: %w", err)
+	}
+	r.ID = id
+	flags, err := s.Byte()
+	if err != nil {
+		return fmt.Errorf("invalid cget request: %w", err)
+	} else if flags&0xf0 != 0 {
+		return errors.New("invalid cget request: unknown flags")
+	}
+	r.Compress = flags&1 != 0
+	r.Checksum = Checksum(flags >> 1 & 7)
+	r.Key = s.Rest()
+	return nil
+}
+
+// CodedValue is the encoding wrapper for a value together with its codec. It
+// is the response to ZGet and CheckedGet requests, and the format in which a
+// service stores values when [ServiceOptions] StoreCompressed is set.
+type CodedValue struct {
+	Codec Codec
+	Data  []byte
+
+	// If not ChecksumNone, Sum is the checksum of the plain value.
+	Checksum Checksum
+	Sum      []byte
+
+	// Encoding:
+	// [1] tag [Vn] sumlen [n] sum [rest] data
+	//
+	// Bits 0-3 of tag hold the codec, and bits 4-7 hold the checksum. The sum
+	// is present only if the checksum is not ChecksumNone.
+}
+
+// Encode converts v into a binary string.
+func (v CodedValue) Encode() []byte {
+	var b packet.Builder
+	b.Grow(1 + packet.VLen(len(v.Sum)) + len(v.Data))
+	b.Put(byte(v.Codec&15) | byte(v.Checksum&15)<<4)
+	if v.Checksum != ChecksumNone {
+		b.VPut(v.Sum)
+	}
+	b.Put(v.Data...)
+	return b.Bytes()
+}
+
+// Decode data from binary format and replace the contents of v.
+func (v *CodedValue) Decode(data []byte) error {
+	s := packet.NewScanner(data)
+	tag, err := s.Byte()
+	if err != nil {
+		return errors.New("invalid coded value: missing codec")
+	}
+	v.Codec = Codec(tag & 15)
+	v.Checksum = Checksum(tag >> 4)
+	v.Sum = nil
+	if v.Checksum != ChecksumNone {
+		v.Sum, err = s.VGet()
+		if err != nil {
+			return fmt.Errorf("invalid coded value: malformed checksum: %w", err)
+		}
+	}
+	v.Data = s.Rest()
+	return nil
+}
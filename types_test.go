@@ -38,6 +38,21 @@ func TestTypes(t *testing.T) {
 		Data:    []byte("what's it like in new york city"),
 		Replace: true,
 	}))
+	t.Run("PutRequest/Codec", testRoundTrip(&chirpstore.PutRequest{
+		ID:    13,
+		Key:   []byte("squeezed"),
+		Data:  []byte("\x01\x02\x03"),
+		Codec: chirpstore.CodecDeflate,
+	}))
+	t.Run("PutRequest/Checksum", testRoundTrip(&chirpstore.PutRequest{
+		ID:       14,
+		Key:      []byte("checked"),
+		Data:     []byte("\x04\x05\x06"),
+		Replace:  true,
+		Codec:    chirpstore.CodecDeflate,
+		Checksum: chirpstore.ChecksumCRC32C,
+		Sum:      []byte("\xde\xad\xbe\xef"),
+	}))
 	t.Run("ListRequest", testRoundTrip(&chirpstore.ListRequest{
 		ID:    2,
 		Start: []byte("the coolth of your evening smile"),
@@ -65,6 +80,51 @@ func TestTypes(t *testing.T) {
 	t.Run("LenRequest", testRoundTrip(&chirpstore.LenRequest{
 		ID: 8,
 	}))
+	t.Run("DropRequest", testRoundTrip(&chirpstore.DropRequest{
+		ID:       9,
+		Confirm:  true,
+		Keyspace: []byte("dead end street"),
+		Prefix:   []byte("no through traffic"),
+	}))
+	t.Run("CopyRequest", testRoundTrip(&chirpstore.CopyRequest{
+		Src:     10,
+		Dst:     11,
+		Replace: true,
+		Keys:    []string{"over", "", "the rainbow"},
+	}))
+	t.Run("DigestRequest", testRoundTrip(&chirpstore.DigestRequest{
+		ID:    12,
+		Split: 16,
+		Start: []byte("from the top"),
+		End:   []byte("to the bottom"),
+	}))
+	t.Run("DigestResponse", testRoundTrip(&chirpstore.DigestResponse{
+		Ranges: []chirpstore.DigestRange{
+			{Start: []byte{}, Count: 0, Sum: []byte("nothing")},
+			{Start: []byte("middle"), Count: 1 << 40, Sum: []byte("everything")},
+		},
+	}))
+	t.Run("NamesResponse", testRoundTrip(&chirpstore.NamesResponse{
+		Names: []string{"", "alpha", "bravo charlie"},
+	}))
+	t.Run("MultiGetResponse", testRoundTrip(&chirpstore.MultiGetResponse{
+		Values: [][]byte{[]byte("present"), nil, {}, []byte("also present")},
+	}))
+	t.Run("CodedValue", testRoundTrip(&chirpstore.CodedValue{
+		Codec: chirpstore.CodecDeflate,
+		Data:  []byte("compressed, honest"),
+	}))
+	t.Run("CodedValue/Checksum", testRoundTrip(&chirpstore.CodedValue{
+		Data:     []byte("plain and summed"),
+		Checksum: chirpstore.ChecksumSHA256,
+		Sum:      []byte("0123456789abcdef0123456789abcdef"),
+	}))
+	t.Run("CheckedGetRequest", testRoundTrip(&chirpstore.CheckedGetRequest{
+		ID:       15,
+		Key:      []byte("trust but verify"),
+		Checksum: chirpstore.ChecksumSHA256,
+		Compress: true,
+	}))
 }
 
 func keyBytes(keys ...string) [][]byte {
@@ -0,0 +1,135 @@
+package chirpstore
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/coder/websocket"
+	"github.com/creachadair/chirp"
+)
+
+// WebSocketOptions are optional settings for [Service.WebSocketHandler] and
+// [DialWebSocket]. A nil *WebSocketOptions is ready for use and provides
+// default values.
+type WebSocketOptions struct {
+	// Host patterns for the origins from which the handler accepts
+	// connections, in addition to the host of the request itself. This is
+	// needed for browser clients served from a different origin. The patterns
+	// are matched as for [path.Match]. Used only by the handler.
+	OriginPatterns []string
+
+	// The HTTP client used to dial. If nil, [http.DefaultClient] is used.
+	// Used only by DialWebSocket.
+	HTTPClient *http.Client
+
+	// Additional headers to send with the dial request, for example to
+	// authenticate to a proxy. Used only by DialWebSocket.
+	Header http.Header
+}
+
+func (o *WebSocketOptions) acceptOptions() *websocket.AcceptOptions {
+	if o == nil {
+		return nil
+	}
+	return &websocket.AcceptOptions{OriginPatterns: o.OriginPatterns}
+}
+
+func (o *WebSocketOptions) dialOptions() *websocket.DialOptions {
+	if o == nil {
+		return nil
+	}
+	return &websocket.DialOptions{HTTPClient: o.HTTPClient, HTTPHeader: o.Header}
+}
+
+// WebSocketHandler returns an [http.Handler] that accepts WebSocket
+// connections and serves each on a new peer with the methods of s registered,
+// as [Service.ServeChannel] does. Each packet is carried in a single binary
+// WebSocket message. The peer runs until the client closes the connection.
+//
+// If the request was made over TLS, the handlers for the peer can recover the
+// client's verified certificate using [PeerCertificate].
+func (s *Service) WebSocketHandler(opts *WebSocketOptions) http.Handler {
+	aopts := opts.acceptOptions()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := websocket.Accept(w, r, aopts)
+		if err != nil {
+			return // Accept has already written an error response
+		}
+		ctx := r.Context()
+		if r.TLS != nil {
+			state := *r.TLS
+			ctx = withTLSState(ctx, func() tls.ConnectionState { return state })
+		}
+		s.ServeChannel(ctx, newWSChannel(conn))
+	})
+}
+
+// DialWebSocket connects to the WebSocket endpoint at url (with scheme "ws" or
+// "wss"), served by [Service.WebSocketHandler], and returns a peer connected
+// to it.
+func DialWebSocket(ctx context.Context, url string, opts *WebSocketOptions) (*chirp.Peer, error) {
+	conn, _, err := websocket.Dial(ctx, url, opts.dialOptions())
+	if err != nil {
+		return nil, err
+	}
+	return chirp.NewPeer().Start(newWSChannel(conn)), nil
+}
+
+// wsChannel is a [chirp.Channel] that sends each packet as a binary message
+// on a WebSocket connection.
+type wsChannel struct {
+	conn *websocket.Conn
+}
+
+func newWSChannel(conn *websocket.Conn) wsChannel {
+	conn.SetReadLimit(-1) // packets are bounded by the peer, not the socket
+	return wsChannel{conn: conn}
+}
+
+// Send implements a method of the [chirp.Channel] interface.
+func (c wsChannel) Send(pkt chirp.Packet) error {
+	w, err := c.conn.Writer(context.Background(), websocket.MessageBinary)
+	if err != nil {
+		return wsError(err)
+	}
+	if _, err := pkt.WriteTo(w); err != nil {
+		w.Close()
+		return wsError(err)
+	}
+	return wsError(w.Close())
+}
+
+// Recv implements a method of the [chirp.Channel] interface.
+func (c wsChannel) Recv() (chirp.Packet, error) {
+	var pkt chirp.Packet
+	typ, r, err := c.conn.Reader(context.Background())
+	if err != nil {
+		return pkt, wsError(err)
+	} else if typ != websocket.MessageBinary {
+		return pkt, fmt.Errorf("unexpected %v message", typ)
+	}
+	if _, err := pkt.ReadFrom(r); err != nil {
+		return pkt, wsError(err)
+	}
+	if n, _ := io.Copy(io.Discard, r); n != 0 {
+		return pkt, fmt.Errorf("extra data (%d bytes) after packet", n)
+	}
+	return pkt, nil
+}
+
+// Close implements a method of the [chirp.Channel] interface.
+func (c wsChannel) Close() error {
+	return wsError(c.conn.Close(websocket.StatusNormalClosure, ""))
+}
+
+// wsError translates a normal closure of the connection to io.EOF, which the
+// peer treats as a clean exit.
+func wsError(err error) error {
+	if websocket.CloseStatus(err) == websocket.StatusNormalClosure {
+		return io.EOF
+	}
+	return err
+}
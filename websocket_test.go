@@ -0,0 +1,41 @@
+package chirpstore_test
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/creachadair/chirpstore"
+	"github.com/creachadair/ffs/blob"
+	"github.com/creachadair/ffs/blob/memstore"
+	"github.com/creachadair/ffs/blob/storetest"
+)
+
+func TestWebSocket(t *testing.T) {
+	svc := chirpstore.NewService(memstore.New(nil), nil)
+	srv := httptest.NewServer(svc.WebSocketHandler(nil))
+	defer srv.Close()
+
+	peer, err := chirpstore.DialWebSocket(t.Context(), "ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("DialWebSocket: %v", err)
+	}
+	rs := chirpstore.NewStore(peer, nil)
+
+	t.Run("Large", func(t *testing.T) {
+		kv := mustKV(t, rs, "large")
+		want := bytes.Repeat([]byte("0123456789"), 100000)
+		if err := kv.Put(t.Context(), blob.PutOptions{Key: "big", Data: want}); err != nil {
+			t.Fatalf("Put: unexpected error: %v", err)
+		}
+		if got, err := kv.Get(t.Context(), "big"); err != nil {
+			t.Errorf("Get: unexpected error: %v", err)
+		} else if !bytes.Equal(got, want) {
+			t.Errorf("Get: got %d bytes, want %d", len(got), len(want))
+		}
+	})
+
+	// N.B. This closes the store, so it must be last.
+	t.Run("Store", func(t *testing.T) { storetest.Run(t, rs) })
+}